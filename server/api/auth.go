@@ -0,0 +1,27 @@
+package api
+
+import (
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+// AuthCheck is a cheap way for clients to find out whether their token is still valid.
+// Invalid tokens are rejected by the jwt middleware with a 401 and a machine-readable code.
+var AuthCheck = router.NewEndpoint(
+	"/api/auth/check",
+	false,
+	"application/json",
+	func(request router.Request) (interface{}, *obj.HTTPError) {
+		if request.User == nil {
+			return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
+		}
+		type AuthCheckResponse struct {
+			UserId uint   `json:"userId"`
+			Name   string `json:"name"`
+		}
+		return AuthCheckResponse{
+			UserId: request.User.ID,
+			Name:   request.User.Name,
+		}, nil
+	},
+)
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"net/http"
 	"path"
 	"strconv"
+	"strings"
+	"time"
+	"webapp-server/gpt"
 	"webapp-server/obj"
 	"webapp-server/router"
 )
@@ -13,71 +18,244 @@ var Game = router.NewEndpoint(
 	"/api/game/",
 	false,
 	"application/json",
-	func(request router.Request) (interface{}, *obj.HTTPError) {
-		if request.User == nil {
-			return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
-		}
-		log.Printf("Handling game request for user %s", request.User.Name)
-
-		// new game?
-		if path.Base(request.R.URL.Path) == "new" {
-			log.Printf("Creating new game..")
-			type GameNewRequest struct {
-				Title string `json:"title"`
-			}
-			var gameRequest GameNewRequest
-			if err := json.NewDecoder(request.R.Body).Decode(&gameRequest); err != nil {
-				return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
-			}
-			newGame := obj.Game{
-				Title: gameRequest.Title,
-				StatusFields: []obj.StatusField{
-					{Name: "Gold", Value: "100"},
-				},
-			}
-			if err := request.User.CreateGame(&newGame); err != nil {
-				return nil, &obj.HTTPError{StatusCode: 500, Message: "Failed to create game: " + err.Error()}
-			}
-			type GameNewResponse struct {
-				GameId uint `json:"id"`
-			}
-			log.Printf("Created new game with id %d", newGame.ID)
-			return GameNewResponse{
-				GameId: newGame.ID,
-			}, nil
-
-		}
-
-		gameId, err := strconv.ParseUint(path.Base(request.R.URL.Path), 10, 32)
-		log.Printf("gameId: %d, method: %s", gameId, request.R.Method)
+	handleGameRequest,
+)
+
+func handleGameRequest(request router.Request) (interface{}, *obj.HTTPError) {
+	if request.User == nil {
+		return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
+	}
+	obj.Logf(request.Ctx, "Handling game request for user %s", request.User.Name)
+
+	// new game?
+	if path.Base(request.R.URL.Path) == "new" {
+		obj.Logf(request.Ctx, "Creating new game..")
+		type GameNewRequest struct {
+			Title string `json:"title"`
+		}
+		var gameRequest GameNewRequest
+		if err := json.NewDecoder(request.R.Body).Decode(&gameRequest); err != nil {
+			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+		}
+		newGame := obj.Game{
+			Title: gameRequest.Title,
+			StatusFields: []obj.StatusField{
+				{Name: "Gold", Value: "100"},
+			},
+		}
+		if err := request.User.CreateGame(&newGame); err != nil {
+			return nil, &obj.HTTPError{StatusCode: 500, Message: "Failed to create game: " + err.Error()}
+		}
+		type GameNewResponse struct {
+			GameId uint `json:"id"`
+		}
+		obj.Logf(request.Ctx, "Created new game with id %d", newGame.ID)
+		return GameNewResponse{
+			GameId: newGame.ID,
+		}, nil
+
+	}
+
+	// sessions of a game?
+	if path.Base(request.R.URL.Path) == "sessions" {
+		return handleGameSessions(request)
+	}
+
+	// restore a deleted game?
+	if path.Base(request.R.URL.Path) == "restore" {
+		return handleGameRestore(request)
+	}
+
+	// cover image of a game?
+	if path.Base(request.R.URL.Path) == "cover" {
+		return handleGameCover(request)
+	}
+
+	// import or export of a game?
+	if path.Base(request.R.URL.Path) == "import" {
+		return handleGameImport(request)
+	}
+	if path.Base(request.R.URL.Path) == "export" {
+		return handleGameExport(request)
+	}
+
+	gameId, err := strconv.ParseUint(path.Base(request.R.URL.Path), 10, 32)
+	obj.Logf(request.Ctx, "gameId: %d, method: %s", gameId, request.R.Method)
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+	}
+	switch request.R.Method {
+	case "DELETE":
+		obj.Logf(request.Ctx, "Deleting game %d", gameId)
+		return nil, request.User.DeleteGame(uint(gameId))
+	case "GET":
+		obj.Logf(request.Ctx, "Getting game %d", gameId)
+		if request.R.URL.Query().Get("includeDeleted") == "true" {
+			return request.User.GetGameIncludingDeleted(uint(gameId))
+		}
+		return request.User.GetGame(request.Ctx, uint(gameId))
+
+	case "POST":
+		obj.Logf(request.Ctx, "Updating game %d", gameId)
+		var updatedGame obj.Game // Replace GameType with your actual game struct type
+		err := json.NewDecoder(request.R.Body).Decode(&updatedGame)
 		if err != nil {
 			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
 		}
-		switch request.R.Method {
-		case "DELETE":
-			log.Printf("Deleting game %d", gameId)
-			return nil, request.User.DeleteGame(uint(gameId))
-		case "GET":
-			log.Printf("Getting game %d", gameId)
-			return request.User.GetGame(uint(gameId))
-
-		case "POST":
-			log.Printf("Updating game %d", gameId)
-			var updatedGame obj.Game // Replace GameType with your actual game struct type
-			err := json.NewDecoder(request.R.Body).Decode(&updatedGame)
-			if err != nil {
-				return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
-			}
-
-			err = request.User.UpdateGame(updatedGame)
-			if err != nil {
-				return nil, &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error"}
-			}
-
-			return request.User.GetGame(uint(gameId))
-
-		default:
-			return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
-		}
-	},
+		if httpErr := validateGameSettings(updatedGame); httpErr != nil {
+			return nil, httpErr
+		}
+
+		err = request.User.UpdateGame(updatedGame)
+		if err != nil {
+			return nil, &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error"}
+		}
+
+		return request.User.GetGame(request.Ctx, uint(gameId))
+
+	default:
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+}
+
+const (
+	// minOutputTokens is the smallest limit that still leaves room for a complete story json
+	minOutputTokens = 256
+	// maxOutputTokens is the output limit of the smallest model initAssistant may pick
+	maxOutputTokens = 4096
+
+	maxGameTags      = 10
+	maxGameTagLength = 32
+)
+
+// validateGameSettings checks the AI settings of a game against the ranges accepted by the provider
+func validateGameSettings(game obj.Game) *obj.HTTPError {
+	if game.Temperature != nil && (*game.Temperature < 0 || *game.Temperature > 2) {
+		return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: fmt.Sprintf("Bad Request - temperature must be between 0 and 2, got %v", *game.Temperature)}
+	}
+	if game.TopP != nil && (*game.TopP < 0 || *game.TopP > 1) {
+		return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: fmt.Sprintf("Bad Request - topP must be between 0 and 1, got %v", *game.TopP)}
+	}
+	if game.MaxOutputTokens != 0 && (game.MaxOutputTokens < minOutputTokens || game.MaxOutputTokens > maxOutputTokens) {
+		return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: fmt.Sprintf("Bad Request - maxOutputTokens must be between %d and %d, got %d", minOutputTokens, maxOutputTokens, game.MaxOutputTokens)}
+	}
+	if len(game.Tags) > maxGameTags {
+		return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: fmt.Sprintf("Bad Request - a game may have at most %d tags", maxGameTags)}
+	}
+	for _, tag := range game.Tags {
+		if len(tag) > maxGameTagLength || strings.Contains(tag, ",") {
+			return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: fmt.Sprintf("Bad Request - tags must be at most %d characters long and contain no commas, got '%s'", maxGameTagLength, tag)}
+		}
+	}
+	if game.MinTurns < 0 || game.MaxTurns < 0 {
+		return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: "Bad Request - minTurns and maxTurns must not be negative"}
+	}
+	if game.MaxTurns > 0 && game.MinTurns > game.MaxTurns {
+		return &obj.HTTPError{StatusCode: 400, Code: obj.ErrorCodeInvalidGameSetting, Message: fmt.Sprintf("Bad Request - minTurns (%d) must not exceed maxTurns (%d)", game.MinTurns, game.MaxTurns)}
+	}
+	return nil
+}
+
+const (
+	gameSessionsDefaultLimit = 50
+	gameSessionsMaxLimit     = 200
 )
+
+// handleGameSessions lists the sessions of all players of a game for its owner: GET /api/game/{id}/sessions?since=&limit=&offset=
+func handleGameSessions(request router.Request) (interface{}, *obj.HTTPError) {
+	if request.R.Method != "GET" {
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+	gameId, err := strconv.ParseUint(path.Base(path.Dir(request.R.URL.Path)), 10, 32)
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+	}
+
+	query := request.R.URL.Query()
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - since must be an RFC3339 timestamp"}
+		}
+	}
+	limit := gameSessionsDefaultLimit
+	if raw := query.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 {
+			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - invalid limit"}
+		}
+		limit = min(limit, gameSessionsMaxLimit)
+	}
+	offset := 0
+	if raw := query.Get("offset"); raw != "" {
+		if offset, err = strconv.Atoi(raw); err != nil || offset < 0 {
+			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - invalid offset"}
+		}
+	}
+
+	obj.Logf(request.Ctx, "Listing sessions of game %d", gameId)
+	return request.User.GetGameSessions(uint(gameId), since, limit, offset)
+}
+
+// handleGameRestore undoes the deletion of a game: POST /api/game/{id}/restore
+func handleGameRestore(request router.Request) (interface{}, *obj.HTTPError) {
+	if request.R.Method != "POST" {
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+	gameId, err := strconv.ParseUint(path.Base(path.Dir(request.R.URL.Path)), 10, 32)
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+	}
+
+	obj.Logf(request.Ctx, "Restoring game %d", gameId)
+	if httpErr := request.User.RestoreGame(uint(gameId)); httpErr != nil {
+		return nil, httpErr
+	}
+	return request.User.GetGame(request.Ctx, uint(gameId))
+}
+
+// handleGameCover serves the cover image of a game (GET) or generates a new one with the owner's key (POST): /api/game/{id}/cover
+func handleGameCover(request router.Request) (interface{}, *obj.HTTPError) {
+	gameId, err := strconv.ParseUint(path.Base(path.Dir(request.R.URL.Path)), 10, 32)
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+	}
+
+	switch request.R.Method {
+	case "GET":
+		image, httpErr := request.User.GetGameTitleImage(uint(gameId))
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		if len(image) == 0 {
+			return nil, &obj.HTTPError{StatusCode: 404, Message: "Image not found"}
+		}
+		return &obj.File{Name: fmt.Sprintf("game-%d.png", gameId), ContentType: "image/png", Content: image}, nil
+
+	case "POST":
+		game, httpErr := request.User.GetGame(request.Ctx, uint(gameId))
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		if request.User.OpenAiKeyPersonal == "" {
+			return nil, &obj.HTTPError{StatusCode: 401, Code: obj.ErrorCodeApiKeyUnavailable, Message: "Unauthorized - missing API key for generating the cover image"}
+		}
+
+		obj.Logf(request.Ctx, "Generating cover image for game %d", gameId)
+		prompt := fmt.Sprintf("Cover art for the adventure game '%s': %s - %s", game.Title, game.Description, game.ImageStyle)
+		imageCtx, cancel := context.WithTimeout(request.Ctx, gpt.ImageGenerationTimeout)
+		defer cancel()
+		image, httpErr := gpt.GenerateImage(imageCtx, request.User.OpenAiKeyPersonal, prompt)
+		if httpErr != nil {
+			// keep the previous cover, the game stays playable without a new one
+			obj.Logf(request.Ctx, "Failed generating cover image for game %d: %s", gameId, httpErr.Message)
+			return nil, &obj.HTTPError{StatusCode: http.StatusBadGateway, Code: obj.ErrorCodeImageGenerationUnavailable, Message: "Image generation is currently unavailable - the cover image was not changed"}
+		}
+		if httpErr = request.User.SetGameTitleImage(uint(gameId), image); httpErr != nil {
+			return nil, httpErr
+		}
+		return request.User.GetGame(request.Ctx, uint(gameId))
+
+	default:
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+// handleGameExport returns a game as portable bundle: GET /api/game/{id}/export
+func handleGameExport(request router.Request) (interface{}, *obj.HTTPError) {
+	if request.R.Method != "GET" {
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+	gameId, err := strconv.ParseUint(path.Base(path.Dir(request.R.URL.Path)), 10, 32)
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+	}
+
+	game, httpErr := request.User.GetGame(request.Ctx, uint(gameId))
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if game.TitleImage, httpErr = request.User.GetGameTitleImage(uint(gameId)); httpErr != nil {
+		return nil, httpErr
+	}
+
+	content, _ := json.MarshalIndent(gameToExport(game), "", "  ")
+	return &obj.File{Name: fmt.Sprintf("game-%d.json", gameId), ContentType: "application/json", Content: content}, nil
+}
+
+// gameImportMaxBytes limits the size of imported bundles, which mostly consists of the base64 encoded cover image
+const gameImportMaxBytes = 16 << 20
+
+// handleGameImport creates a new game of the user from a bundle created by handleGameExport: POST /api/game/import
+func handleGameImport(request router.Request) (interface{}, *obj.HTTPError) {
+	if request.R.Method != "POST" {
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+	var export obj.GameExport
+	if err := json.NewDecoder(http.MaxBytesReader(nil, request.R.Body, gameImportMaxBytes)).Decode(&export); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &obj.HTTPError{StatusCode: http.StatusRequestEntityTooLarge, Code: obj.ErrorCodePayloadTooLarge, Message: fmt.Sprintf("Request Entity Too Large - game exports may have at most %d MB", gameImportMaxBytes>>20)}
+		}
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
+	}
+	game, httpErr := exportToGame(export)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	if err := request.User.ImportGame(game); err != nil {
+		return nil, &obj.HTTPError{StatusCode: 500, Message: "Failed to import game: " + err.Error()}
+	}
+	obj.Logf(request.Ctx, "Imported game '%s' as game %d", game.Title, game.ID)
+	return request.User.GetGame(request.Ctx, game.ID)
+}
+
+func gameToExport(game *obj.Game) obj.GameExport {
+	return obj.GameExport{
+		Format:              obj.GameExportFormat,
+		Version:             obj.GameExportVersion,
+		Title:               game.Title,
+		Description:         game.Description,
+		Scenario:            game.Scenario,
+		SessionStartSyscall: game.SessionStartSyscall,
+		StatusFields:        game.StatusFields,
+		Tags:                game.Tags,
+		ImageStyle:          game.ImageStyle,
+		Temperature:         game.Temperature,
+		TopP:                game.TopP,
+		MaxOutputTokens:     game.MaxOutputTokens,
+		MinTurns:            game.MinTurns,
+		MaxTurns:            game.MaxTurns,
+		TitleImage:          game.TitleImage,
+	}
+}
+
+func exportToGame(export obj.GameExport) (*obj.Game, *obj.HTTPError) {
+	if export.Format != obj.GameExportFormat {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - not a game export"}
+	}
+	if export.Version < 1 || export.Version > obj.GameExportVersion {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: fmt.Sprintf("Bad Request - unsupported game export version %d", export.Version)}
+	}
+	game := &obj.Game{
+		Title:               export.Title,
+		TitleImage:          export.TitleImage,
+		Description:         export.Description,
+		Scenario:            export.Scenario,
+		SessionStartSyscall: export.SessionStartSyscall,
+		StatusFields:        export.StatusFields,
+		Tags:                export.Tags,
+		ImageStyle:          export.ImageStyle,
+		Temperature:         export.Temperature,
+		TopP:                export.TopP,
+		MaxOutputTokens:     export.MaxOutputTokens,
+		MinTurns:            export.MinTurns,
+		MaxTurns:            export.MaxTurns,
+	}
+	// the cover is served as image, so it must be one
+	if len(game.TitleImage) > 0 {
+		if contentType := http.DetectContentType(game.TitleImage); contentType != "image/png" && contentType != "image/jpeg" {
+			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - the title image must be a png or jpeg, got " + contentType}
+		}
+	}
+	if game.StatusFields == nil {
+		game.StatusFields = []obj.StatusField{}
+	}
+	if httpErr := validateGameSettings(*game); httpErr != nil {
+		return nil, httpErr
+	}
+	return game, nil
+}
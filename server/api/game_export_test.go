@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+func TestGameExportRoundTrip(t *testing.T) {
+	temperature := float32(0.7)
+	game := &obj.Game{
+		ID:              12,
+		Title:           "The Castle",
+		Scenario:        "A castle in the mist",
+		StatusFields:    []obj.StatusField{{Name: "Gold", Value: "100"}},
+		Tags:            []string{"fantasy"},
+		ImageStyle:      "watercolor",
+		Temperature:     &temperature,
+		TitleImage:      []byte("\x89PNG\r\n\x1a\n..."),
+		SharePlayActive: true,
+		SharePlayHash:   "SECRET",
+		SponsorNotice:   "Sponsored by me",
+		UserId:          3,
+	}
+
+	bundle, err := json.Marshal(gameToExport(game))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(bundle), "SECRET")
+	assert.NotContains(t, string(bundle), "Sponsored by me")
+
+	var export obj.GameExport
+	assert.NoError(t, json.Unmarshal(bundle, &export))
+	imported, httpErr := exportToGame(export)
+	assert.Nil(t, httpErr)
+	assert.Equal(t, uint(0), imported.ID)
+	assert.Equal(t, uint(0), imported.UserId)
+	assert.Equal(t, game.Title, imported.Title)
+	assert.Equal(t, game.StatusFields, imported.StatusFields)
+	assert.Equal(t, game.Tags, imported.Tags)
+	assert.Equal(t, game.TitleImage, imported.TitleImage)
+	assert.Equal(t, temperature, *imported.Temperature)
+	assert.False(t, imported.SharePlayActive)
+	assert.Empty(t, imported.SharePlayHash)
+	assert.Empty(t, imported.SponsorNotice)
+
+	_, httpErr = exportToGame(obj.GameExport{Format: obj.GameExportFormat, Version: 1, TitleImage: []byte("<script>alert(1)</script>")})
+	assert.NotNil(t, httpErr)
+	_, httpErr = exportToGame(obj.GameExport{Format: "something-else", Version: 1})
+	assert.NotNil(t, httpErr)
+	_, httpErr = exportToGame(obj.GameExport{Format: obj.GameExportFormat, Version: obj.GameExportVersion + 1})
+	assert.NotNil(t, httpErr)
+}
+
+func TestGameImportTooLarge(t *testing.T) {
+	body := strings.NewReader(`{"format":"` + obj.GameExportFormat + `","titleImage":"` + strings.Repeat("A", gameImportMaxBytes) + `"}`)
+	_, httpErr := handleGameImport(router.Request{R: httptest.NewRequest("POST", "/api/game/import", body), Ctx: context.Background()})
+	if assert.NotNil(t, httpErr) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, httpErr.StatusCode)
+		assert.Equal(t, obj.ErrorCodePayloadTooLarge, httpErr.ErrorCode())
+	}
+}
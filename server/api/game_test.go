@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"webapp-server/db"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+func TestValidateGameSettings(t *testing.T) {
+	value := func(v float32) *float32 { return &v }
+
+	assert.Nil(t, validateGameSettings(obj.Game{}))
+	assert.Nil(t, validateGameSettings(obj.Game{Temperature: value(0), TopP: value(1)}))
+	assert.Nil(t, validateGameSettings(obj.Game{Temperature: value(1.3)}))
+	assert.NotNil(t, validateGameSettings(obj.Game{Temperature: value(2.5)}))
+	assert.NotNil(t, validateGameSettings(obj.Game{Temperature: value(-0.1)}))
+	assert.NotNil(t, validateGameSettings(obj.Game{TopP: value(1.1)}))
+	assert.Nil(t, validateGameSettings(obj.Game{MaxOutputTokens: 1000}))
+	assert.NotNil(t, validateGameSettings(obj.Game{MaxOutputTokens: 10}))
+	assert.NotNil(t, validateGameSettings(obj.Game{MaxOutputTokens: 100000}))
+	assert.Nil(t, validateGameSettings(obj.Game{MinTurns: 5, MaxTurns: 10}))
+	assert.Nil(t, validateGameSettings(obj.Game{MinTurns: 5}))
+	assert.NotNil(t, validateGameSettings(obj.Game{MinTurns: 11, MaxTurns: 10}))
+	assert.NotNil(t, validateGameSettings(obj.Game{MaxTurns: -1}))
+	assert.Nil(t, validateGameSettings(obj.Game{Tags: []string{"rpg", "horror"}}))
+	assert.NotNil(t, validateGameSettings(obj.Game{Tags: []string{"rpg,horror"}}))
+	assert.NotNil(t, validateGameSettings(obj.Game{Tags: make([]string, maxGameTags+1)}))
+}
+
+func initTestDb(t *testing.T) {
+	if err := db.Open(path.Join(t.TempDir(), "sqlite.db")); err != nil {
+		t.Fatalf("failed opening test database: %s", err)
+	}
+}
+
+func TestGetDeletedGame(t *testing.T) {
+	initTestDb(t)
+	owner := &db.User{Auth0ID: "owner"}
+	assert.NoError(t, db.CreateUser(owner))
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, owner.CreateGame(&game))
+	assert.Nil(t, owner.DeleteGame(game.ID))
+
+	get := func(target string) (interface{}, *obj.HTTPError) {
+		return handleGameRequest(router.Request{R: httptest.NewRequest("GET", target, nil), User: owner, Ctx: context.Background()})
+	}
+	_, httpErr := get(fmt.Sprintf("/api/game/%d", game.ID))
+	if assert.NotNil(t, httpErr) {
+		assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+	}
+	deleted, httpErr := get(fmt.Sprintf("/api/game/%d?includeDeleted=true", game.ID))
+	assert.Nil(t, httpErr)
+	assert.NotNil(t, deleted.(*obj.Game).DeletedAt)
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"net/url"
+	"strconv"
+	"strings"
 	"webapp-server/obj"
 	"webapp-server/router"
 )
@@ -13,7 +16,90 @@ var Games = router.NewEndpoint(
 		if request.User == nil {
 			return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
 		}
-		games, err := request.User.GetGames()
-		return games, err
+		options, httpErr := parseGameListOptions(request.R.URL.Query())
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		games, httpErr := request.User.ListGames(options)
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		// callers paging through the games get the total, the others the plain list as before paging existed
+		if options.Limit > 0 {
+			return games, nil
+		}
+		return games.Games, nil
+	},
+)
+
+// gamesMaxLimit caps the page size of callers paging through the games
+const gamesMaxLimit = 500
+
+// parseGameListOptions reads the filters of GET /api/games?search=&tag=&mine=&public=&sort=&order=&limit=&offset=
+// Without mine and public filters only the user's own games are listed, and without limit all of them,
+// as before the filters existed.
+func parseGameListOptions(query url.Values) (obj.GameListOptions, *obj.HTTPError) {
+	options := obj.GameListOptions{
+		Search: strings.TrimSpace(query.Get("search")),
+		Tag:    strings.TrimSpace(query.Get("tag")),
+		Sort:   obj.GameSortCreatedAt,
+	}
+	for name, target := range map[string]**bool{"mine": &options.Mine, "public": &options.Public} {
+		if raw := query.Get(name); raw != "" {
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				return options, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - " + name + " must be true or false"}
+			}
+			*target = &value
+		}
+	}
+	if options.Mine == nil && options.Public == nil {
+		mine := true
+		options.Mine = &mine
+	}
+	if raw := query.Get("sort"); raw != "" {
+		options.Sort = raw
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		options.Descending = true
+	default:
+		return options, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - order must be asc or desc"}
+	}
+	var err error
+	if raw := query.Get("limit"); raw != "" {
+		if options.Limit, err = strconv.Atoi(raw); err != nil || options.Limit < 1 {
+			return options, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - invalid limit"}
+		}
+		options.Limit = min(options.Limit, gamesMaxLimit)
+	}
+	if raw := query.Get("offset"); raw != "" {
+		if options.Offset, err = strconv.Atoi(raw); err != nil || options.Offset < 0 {
+			return options, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - invalid offset"}
+		}
+	}
+	return options, nil
+}
+
+const recentGamesLimit = 10
+
+// GamesRecent lists the games the user played most recently: GET /api/games/recent?limit=
+var GamesRecent = router.NewEndpoint(
+	"/api/games/recent",
+	false,
+	"application/json",
+	func(request router.Request) (interface{}, *obj.HTTPError) {
+		if request.User == nil {
+			return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
+		}
+		limit := recentGamesLimit
+		if raw := request.R.URL.Query().Get("limit"); raw != "" {
+			var err error
+			if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > 50 {
+				return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - limit must be between 1 and 50"}
+			}
+		}
+		return request.User.GetRecentGames(limit)
 	},
 )
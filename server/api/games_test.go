@@ -0,0 +1,33 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+	"webapp-server/obj"
+)
+
+func TestParseGameListOptions(t *testing.T) {
+	options, httpErr := parseGameListOptions(url.Values{})
+	assert.Nil(t, httpErr)
+	if assert.NotNil(t, options.Mine) {
+		assert.True(t, *options.Mine)
+	}
+	assert.Nil(t, options.Public)
+	assert.Equal(t, obj.GameSortCreatedAt, options.Sort)
+	assert.Zero(t, options.Limit)
+
+	options, httpErr = parseGameListOptions(url.Values{"public": {"true"}, "search": {" castle "}, "tag": {"rpg"}, "order": {"desc"}, "limit": {"9999"}})
+	assert.Nil(t, httpErr)
+	assert.Nil(t, options.Mine)
+	assert.True(t, *options.Public)
+	assert.Equal(t, "castle", options.Search)
+	assert.Equal(t, "rpg", options.Tag)
+	assert.True(t, options.Descending)
+	assert.Equal(t, gamesMaxLimit, options.Limit)
+
+	for _, query := range []url.Values{{"mine": {"maybe"}}, {"order": {"up"}}, {"limit": {"0"}}, {"offset": {"-1"}}} {
+		_, httpErr = parseGameListOptions(query)
+		assert.NotNil(t, httpErr, query.Encode())
+	}
+}
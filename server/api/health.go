@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"webapp-server/db"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+// HealthLive tells the orchestrator that the server process is up
+var HealthLive = router.NewEndpoint(
+	"/api/health/live",
+	true,
+	"application/json",
+	func(request router.Request) (interface{}, *obj.HTTPError) {
+		return &obj.JsonResponse{StatusCode: http.StatusOK, Body: map[string]string{"status": healthOk}}, nil
+	},
+)
+
+// the probes answer {"status": "ok"|"fail"}, the readiness probe adds the state of each subsystem
+const (
+	healthOk   = "ok"
+	healthFail = "fail"
+)
+
+// HealthReady tells the orchestrator whether the server can handle requests
+var HealthReady = router.NewEndpoint(
+	"/api/health/ready",
+	true,
+	"application/json",
+	func(request router.Request) (interface{}, *obj.HTTPError) {
+		// the body names the state of each subsystem - error details only go to the log
+		status := map[string]string{"status": healthOk, "database": healthOk}
+		statusCode := http.StatusOK
+		if err := db.Ping(); err != nil {
+			obj.Logf(request.Ctx, "Readiness check failed, database: %s", err.Error())
+			status["status"] = healthFail
+			status["database"] = healthFail
+			statusCode = http.StatusServiceUnavailable
+		}
+		return &obj.JsonResponse{StatusCode: statusCode, Body: status}, nil
+	},
+)
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webapp-server/router"
+)
+
+func TestHealthProbesShareTheirFormat(t *testing.T) {
+	initTestDb(t)
+	for _, probe := range []router.Endpoint{HealthLive, HealthReady} {
+		endpoint := probe.Path
+		recorder := httptest.NewRecorder()
+		probe.Handler(recorder, httptest.NewRequest("GET", endpoint, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code, endpoint)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), endpoint)
+		var body map[string]string
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body), endpoint)
+		assert.Equal(t, healthOk, body["status"], endpoint)
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"strconv"
 	"time"
 	"webapp-server/db"
+	"webapp-server/gpt"
 	"webapp-server/lang"
 	"webapp-server/obj"
 	"webapp-server/router"
 )
 
+const imagePollInterval = 1 * time.Second
+
 var Image = router.NewEndpoint(
 	"/api/image/",
 	true,
@@ -30,8 +33,8 @@ var Image = router.NewEndpoint(
 			return nil, &obj.HTTPError{StatusCode: 404, Message: lang.ErrorFailedLoadingGameData}
 		}
 
-		// image creation can take a while - so we query the db until it's ready, or timeout
-		for i := 0; i < 20; i++ {
+		// image creation can take a while - so we query the db until it's ready, or the generation must have given up
+		for deadline := time.Now().Add(gpt.ImageGenerationTimeout + imagePollInterval); time.Now().Before(deadline); {
 			var chapter *obj.Chapter
 			chapter, err = db.GetChapter(session.ID, uint(chapterId))
 			if err != nil {
@@ -41,8 +44,12 @@ var Image = router.NewEndpoint(
 			if chapter.Image != nil && len(chapter.Image) > 0 {
 				return chapter.Image, nil
 			}
+			if chapter.ImageError != "" {
+				// no need to wait any longer - the chapter is text-only
+				return nil, &obj.HTTPError{StatusCode: 404, Code: obj.ErrorCodeImageGenerationFailed, Message: lang.ErrorImageGenerationFailed}
+			}
 
-			time.Sleep(1 * time.Second)
+			time.Sleep(imagePollInterval)
 		}
 
 		return nil, &obj.HTTPError{StatusCode: 404, Message: "Image not found"}
@@ -1,20 +1,35 @@
 package api
 
 import (
-	"log"
 	"path"
 	"webapp-server/db"
 	"webapp-server/obj"
 	"webapp-server/router"
 )
 
+// PublicGame serves games shared for play: GET /api/public/game/{sharePlayHash}
+// and their cover image, which needs no login, so it can be used in image tags: GET /api/public/game/{sharePlayHash}/cover
 var PublicGame = router.NewEndpoint(
 	"/api/public/game/",
 	true,
 	"application/json",
 	func(request router.Request) (interface{}, *obj.HTTPError) {
+		if path.Base(request.R.URL.Path) == "cover" {
+			return publicGameCover(path.Base(path.Dir(request.R.URL.Path)))
+		}
 		gameHash := path.Base(request.R.URL.Path)
-		log.Printf("gameHash: %s, method: %s", gameHash, request.R.Method)
+		obj.Logf(request.Ctx, "gameHash: %s, method: %s", gameHash, request.R.Method)
 		return db.GetGameByPublicHash(gameHash)
 	},
 )
+
+func publicGameCover(gameHash string) (interface{}, *obj.HTTPError) {
+	image, httpErr := db.GetTitleImageByPublicHash(gameHash)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if len(image) == 0 {
+		return nil, &obj.HTTPError{StatusCode: 404, Message: "Image not found"}
+	}
+	return &obj.File{Name: "game-" + gameHash + ".png", ContentType: "image/png", Content: image}, nil
+}
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"net/http"
 	"path"
+	"sync"
+	"time"
 	"webapp-server/db"
 	"webapp-server/gpt"
 	"webapp-server/obj"
@@ -12,14 +17,70 @@ import (
 
 const (
 	userAnonymous = uint(0)
+	// duplicateSessionWindow is how long an unplayed session is handed out again instead of creating a new one
+	duplicateSessionWindow = 30 * time.Second
 )
 
+// sessionSetupTimeout is how long setting up the assistant of a new session may take - the creation lock is held meanwhile
+var sessionSetupTimeout = 1 * time.Minute
+
+// newSessionLocks serializes session creation per user and game, so that a double click can't create two sessions
+var newSessionLocks = keyedLocks{}
+
+// createGameSession sets up the assistant of a new session - replaced in tests, which can't reach the provider
+var createGameSession = gpt.CreateGameSession
+
+// executeAction lets the assistant continue the story of a session - replaced in tests, like createGameSession
+var executeAction = gpt.ExecuteAction
+
+// sessionActionLocks serializes the actions sent to a session
+var sessionActionLocks = keyedLocks{}
+
+// keyedLocks hands out a mutex per key. Entries are dropped once nobody holds or waits for them, so the map doesn't grow forever.
+type keyedLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock blocks until the lock of the key is acquired and returns the function releasing it
+func (k *keyedLocks) Lock(key string) (unlock func()) {
+	k.mutex.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedLock{}
+	}
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		k.mutex.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mutex.Unlock()
+	}
+}
+
 type SessionRequest struct {
 	Action    string `json:"action"`    // type of action
 	ChapterId uint   `json:"chapterId"` // id of action
 	// creating a new session:
 	GameID   uint   `json:"gameId"`
 	GameHash string `json:"gameHash"`
+	ForceNew bool   `json:"forceNew"` // create a new session, even if an unplayed one was just created
+	// public play of sponsored games:
+	SponsorConsent bool `json:"sponsorConsent"`
 	// playing a session:
 	Message string            `json:"message"` // user input
 	Status  []obj.StatusField `json:"status"`
@@ -42,51 +103,57 @@ func handleSessionRequest(request router.Request, public bool) (out interface{},
 	var apiKey string
 
 	sessionHash := path.Base(request.R.URL.Path)
+	if sessionHash == "export" {
+		return exportSession(request)
+	}
+
 	var sessionRequest SessionRequest
 	if err = json.NewDecoder(request.R.Body).Decode(&sessionRequest); err != nil {
 		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
 	}
 
 	if sessionHash == "new" {
-		if apiKey, httpErr = getGamePublicApiKey(sessionRequest.GameID, request.User, public); httpErr != nil {
+		if apiKey, httpErr = getGamePublicApiKey(request.Ctx, sessionRequest.GameID, request.User, public); httpErr != nil {
 			return nil, httpErr
 		}
-		return newSession(request, sessionRequest.GameID, apiKey)
+		return newSession(request, sessionRequest, apiKey, public)
 	}
 
+	// one action at a time per session - the turn count and end of the session are decided on the latest state
+	unlock := sessionActionLocks.Lock(sessionHash)
+	defer unlock()
+
 	if sessionRequest.Session, err = db.GetSessionByHash(sessionHash); err != nil {
 		return nil, &obj.HTTPError{StatusCode: 404, Message: "Not Found"}
 	}
+	if sessionRequest.Session.EndReason != "" {
+		return nil, sessionEndedError(sessionRequest.Session.EndReason)
+	}
 
 	if sessionRequest.Game, err = db.GetGameByID(sessionRequest.Session.GameID); err != nil {
 		return nil, &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error"}
 	}
 
-	if apiKey, httpErr = getGamePublicApiKey(sessionRequest.Game.ID, request.User, public); httpErr != nil {
+	if apiKey, httpErr = getGamePublicApiKey(request.Ctx, sessionRequest.Game.ID, request.User, public); httpErr != nil {
 		return nil, httpErr
 	}
 
 	switch sessionRequest.Action {
 	case obj.GameInputTypeIntro:
-		return gpt.ExecuteAction(sessionRequest.Session, sessionRequest.Game, obj.GameActionInput{
+		return executeAction(request.Ctx, sessionRequest.Session, sessionRequest.Game, obj.GameActionInput{
 			Type:      obj.GameInputTypeIntro,
 			ChapterId: sessionRequest.ChapterId,
 			Message:   sessionRequest.Game.SessionStartSyscall,
 			Status:    sessionRequest.Game.StatusFields,
 		}, apiKey)
 	case obj.GameInputTypeAction:
-		return gpt.ExecuteAction(sessionRequest.Session, sessionRequest.Game, obj.GameActionInput{
-			Type:      obj.GameInputTypeAction,
-			ChapterId: sessionRequest.ChapterId,
-			Message:   sessionRequest.Message,
-			Status:    sessionRequest.Status,
-		}, apiKey)
+		return playerAction(request, sessionRequest, apiKey)
 	default:
 		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - unknown action: " + sessionRequest.Action}
 	}
 }
 
-func getGamePublicApiKey(gameID uint, user *db.User, public bool) (string, *obj.HTTPError) {
+func getGamePublicApiKey(ctx context.Context, gameID uint, user *db.User, public bool) (string, *obj.HTTPError) {
 	var apiKey string
 	if public {
 		game, err := db.GetGameByID(gameID)
@@ -103,23 +170,24 @@ func getGamePublicApiKey(gameID uint, user *db.User, public bool) (string, *obj.
 		if err != nil {
 			return "", &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error - failed to get owner of public game"}
 		}
-		log.Printf("Owner of public game: %+v", owner)
+		obj.Logf(ctx, "Owner of public game: %d", owner.ID)
 		apiKey = owner.OpenAiKeyPublish
 		return owner.OpenAiKeyPublish, nil
 	} else {
 		apiKey = user.OpenAiKeyPersonal
 	}
 	if apiKey == "" {
-		return "", &obj.HTTPError{StatusCode: 401, Message: "Unauthorized - missing API key for session"}
+		return "", &obj.HTTPError{StatusCode: 401, Code: obj.ErrorCodeApiKeyUnavailable, Message: "Unauthorized - missing API key for session"}
 	}
 	return apiKey, nil
 }
 
-func newSession(request router.Request, gameID uint, apiKey string) (*obj.Session, *obj.HTTPError) {
+func newSession(request router.Request, sessionRequest SessionRequest, apiKey string, public bool) (*obj.Session, *obj.HTTPError) {
 	var game *obj.Game
 	var userId uint
+	gameID := sessionRequest.GameID
 	if gameID > 0 {
-		log.Printf("Creating new session for game id=%d", gameID)
+		obj.Logf(request.Ctx, "Creating new session for game id=%d", gameID)
 		var err error
 		if game, err = db.GetGameByID(gameID); err != nil {
 			return nil, &obj.HTTPError{StatusCode: 404, Message: "Not Found - Game not found"}
@@ -130,20 +198,99 @@ func newSession(request router.Request, gameID uint, apiKey string) (*obj.Sessio
 			userId = request.User.ID
 		}
 	} else {
-		log.Printf("Creating new session - no game id or hash provided")
+		obj.Logf(request.Ctx, "Creating new session - no game id or hash provided")
 		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request"}
 	}
 
+	// public games are played on the owner's key - the owner may require players to accept a notice about that
+	if public && game.SponsorConsentRequired && !sessionRequest.SponsorConsent {
+		return nil, &obj.HTTPError{StatusCode: 403, Code: obj.ErrorCodeSponsorConsentRequired, Message: "Forbidden - the sponsor notice of this game must be accepted"}
+	}
+
+	// anonymous players share a user id, so only logged in users get their fresh session back
+	if userId != userAnonymous {
+		unlock := newSessionLocks.Lock(fmt.Sprintf("%d/%d", userId, gameID))
+		defer unlock()
+
+		if !sessionRequest.ForceNew {
+			session, err := db.GetFreshSession(userId, gameID, time.Now().Add(-duplicateSessionWindow))
+			if err != nil {
+				return nil, &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error"}
+			}
+			if session != nil {
+				obj.Logf(request.Ctx, "Returning fresh session %d instead of creating a duplicate", session.ID)
+				return session, nil
+			}
+		}
+	}
+
 	// Build session
-	session, e := gpt.CreateGameSession(game, userId, apiKey)
+	setupCtx, cancel := context.WithTimeout(request.Ctx, sessionSetupTimeout)
+	defer cancel()
+	session, e := createGameSession(setupCtx, game, userId, apiKey)
+	if errors.Is(e, context.DeadlineExceeded) {
+		return nil, &obj.HTTPError{StatusCode: http.StatusGatewayTimeout, Message: "GPT error: session setup took longer than " + sessionSetupTimeout.String()}
+	}
 	if e != nil {
 		return nil, &obj.HTTPError{StatusCode: 500, Message: e.Error()}
 	}
 
 	// Store session
+	session.ApiKeySource = obj.ApiKeySourcePersonal
+	if public {
+		session.ApiKeySource = obj.ApiKeySourceSponsor
+	}
 	if session, e = db.CreateSession(session); e != nil {
 		return nil, &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error"}
 	}
+	if e = db.IncrementPlayCount(game.ID); e != nil {
+		obj.Logf(request.Ctx, "Failed counting play of game %d: %s", game.ID, e)
+	}
+	if public {
+		session.SponsorNotice = game.SponsorNotice
+	}
 
 	return session, nil
 }
+
+// playerAction continues the story with the player's input and ends the session, once the game's turn limit is reached.
+// The caller holds the session's lock, so the turn count of the session is current.
+func playerAction(request router.Request, sessionRequest SessionRequest, apiKey string) (*obj.GameActionOutput, *obj.HTTPError) {
+	session, game := sessionRequest.Session, sessionRequest.Game
+	action := obj.GameActionInput{
+		Type:      obj.GameInputTypeAction,
+		ChapterId: sessionRequest.ChapterId,
+		Message:   sessionRequest.Message,
+		Status:    sessionRequest.Status,
+	}
+	if game.MinTurns > 0 || game.MaxTurns > 0 {
+		action.Turn = session.Turns + 1
+	}
+	var endReason string
+	if game.MaxTurns > 0 {
+		if session.Turns >= game.MaxTurns {
+			// sessions which were played before the limit was lowered
+			if err := db.EndSession(session.ID, obj.SessionEndReasonTurnLimit); err != nil {
+				obj.Logf(request.Ctx, "Failed ending session %d: %s", session.ID, err)
+			}
+			return nil, sessionEndedError(obj.SessionEndReasonTurnLimit)
+		}
+		if action.LastTurn = session.Turns+1 >= game.MaxTurns; action.LastTurn {
+			endReason = obj.SessionEndReasonTurnLimit
+		}
+	}
+
+	response, httpErr := executeAction(request.Ctx, session, game, action, apiKey)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if err := db.RecordTurn(session.ID, endReason); err != nil {
+		obj.Logf(request.Ctx, "Failed recording turn of session %d: %s", session.ID, err)
+	}
+	response.EndReason = endReason
+	return response, nil
+}
+
+func sessionEndedError(reason string) *obj.HTTPError {
+	return &obj.HTTPError{StatusCode: 409, Code: obj.ErrorCodeSessionEnded, Message: "Conflict - the session has ended: " + reason}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"webapp-server/db"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+const (
+	exportFormatJson     = "json"
+	exportFormatMarkdown = "md"
+)
+
+// exportSession returns the transcript of a session as a download: GET /api/session/{hash}/export?format=md|json
+func exportSession(request router.Request) (interface{}, *obj.HTTPError) {
+	if request.R.Method != "GET" {
+		return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+	}
+	sessionHash := path.Base(path.Dir(request.R.URL.Path))
+
+	format := request.R.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatMarkdown
+	}
+	if format != exportFormatMarkdown && format != exportFormatJson {
+		return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - unknown export format: " + format}
+	}
+
+	session, err := db.GetSessionByHash(sessionHash)
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 404, Message: "Not Found"}
+	}
+	var game *obj.Game
+	if game, err = db.GetGameByID(session.GameID); err != nil {
+		obj.Logf(request.Ctx, "Exporting session %d of unavailable game %d", session.ID, session.GameID)
+		game = &obj.Game{ID: session.GameID}
+	}
+	var chapters []obj.Chapter
+	if chapters, err = db.GetChapters(session.ID); err != nil {
+		return nil, &obj.HTTPError{StatusCode: 500, Message: "Internal Server Error"}
+	}
+
+	transcript := buildTranscript(session, game, chapters)
+	if format == exportFormatJson {
+		content, _ := json.MarshalIndent(transcript, "", "  ")
+		return &obj.File{Name: "session-" + session.Hash + ".json", ContentType: "application/json", Content: content}, nil
+	}
+	return &obj.File{Name: "session-" + session.Hash + ".md", ContentType: "text/markdown; charset=utf-8", Content: []byte(renderTranscriptMarkdown(transcript))}, nil
+}
+
+// buildTranscript extracts player actions and story from the raw json exchanged with the assistant
+func buildTranscript(session *obj.Session, game *obj.Game, chapters []obj.Chapter) obj.SessionTranscript {
+	transcript := obj.SessionTranscript{
+		SessionHash: session.Hash,
+		GameID:      game.ID,
+		GameTitle:   game.Title,
+		CreatedAt:   session.CreatedAt,
+		Chapters:    make([]obj.TranscriptChapter, len(chapters)),
+	}
+	for i, chapter := range chapters {
+		var input obj.GameActionInput
+		var output obj.GameActionOutput
+		_ = json.Unmarshal([]byte(chapter.Input), &input)
+		if err := json.Unmarshal([]byte(chapter.Output), &output); err != nil {
+			// the assistant didn't answer with valid json - keep what it said
+			output.Story = chapter.Output
+		}
+		transcript.Chapters[i] = obj.TranscriptChapter{
+			Chapter:     chapter.Chapter,
+			CreatedAt:   chapter.CreatedAt,
+			Type:        input.Type,
+			Action:      input.Message,
+			Story:       output.Story,
+			Status:      output.Status,
+			ImagePrompt: chapter.ImagePrompt,
+		}
+	}
+	return transcript
+}
+
+func renderTranscriptMarkdown(transcript obj.SessionTranscript) string {
+	var sb strings.Builder
+	title := transcript.GameTitle
+	if title == "" {
+		title = fmt.Sprintf("Game #%d", transcript.GameID)
+	}
+	sb.WriteString(fmt.Sprintf("# %s\n\n", title))
+	sb.WriteString(fmt.Sprintf("_Session started %s_\n", transcript.CreatedAt.Format("2006-01-02 15:04")))
+
+	for _, chapter := range transcript.Chapters {
+		sb.WriteString(fmt.Sprintf("\n## Chapter %d\n\n", chapter.Chapter))
+		if chapter.Type == obj.GameInputTypeAction && chapter.Action != "" {
+			sb.WriteString(fmt.Sprintf("> **Player:** %s\n\n", chapter.Action))
+		}
+		sb.WriteString(strings.TrimSpace(chapter.Story) + "\n")
+		if len(chapter.Status) > 0 {
+			fields := make([]string, len(chapter.Status))
+			for i, field := range chapter.Status {
+				fields[i] = fmt.Sprintf("%s: %s", field.Name, field.Value)
+			}
+			sb.WriteString(fmt.Sprintf("\n**Status:** %s\n", strings.Join(fields, " · ")))
+		}
+	}
+	return sb.String()
+}
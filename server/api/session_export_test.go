@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"webapp-server/obj"
+)
+
+func TestBuildTranscript(t *testing.T) {
+	session := &obj.Session{Hash: "abc", CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	game := &obj.Game{ID: 3, Title: "The Castle"}
+	chapters := []obj.Chapter{
+		{Chapter: 1, Input: `{"type":"intro","action":"Introduce the player"}`, Output: `{"story":"You wake up.","status":[{"name":"Gold","value":"100"}]}`},
+		{Chapter: 2, Input: `{"type":"player-action","action":"open the door"}`, Output: `not json`},
+	}
+
+	transcript := buildTranscript(session, game, chapters)
+	assert.Equal(t, "The Castle", transcript.GameTitle)
+	assert.Len(t, transcript.Chapters, 2)
+	assert.Equal(t, "You wake up.", transcript.Chapters[0].Story)
+	assert.Equal(t, []obj.StatusField{{Name: "Gold", Value: "100"}}, transcript.Chapters[0].Status)
+	assert.Equal(t, "open the door", transcript.Chapters[1].Action)
+	assert.Equal(t, "not json", transcript.Chapters[1].Story)
+
+	markdown := renderTranscriptMarkdown(transcript)
+	assert.Contains(t, markdown, "# The Castle")
+	assert.Contains(t, markdown, "**Status:** Gold: 100")
+	assert.Contains(t, markdown, "> **Player:** open the door")
+	assert.NotContains(t, markdown, "Introduce the player")
+}
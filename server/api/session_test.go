@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"webapp-server/db"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+func TestKeyedLocks(t *testing.T) {
+	var locks keyedLocks
+	var wg sync.WaitGroup
+	inside, maxInside := 0, 0
+	var counter sync.Mutex
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.Lock("user/game")
+			defer unlock()
+			counter.Lock()
+			inside++
+			maxInside = max(maxInside, inside)
+			counter.Unlock()
+			counter.Lock()
+			inside--
+			counter.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxInside)
+
+	// other keys don't block, and released keys are forgotten
+	unlock := locks.Lock("a")
+	locks.Lock("b")()
+	unlock()
+	assert.Empty(t, locks.locks)
+}
+
+func TestNewSessionPreventsDuplicates(t *testing.T) {
+	initTestDb(t)
+	player := &db.User{Auth0ID: "player"}
+	assert.NoError(t, db.CreateUser(player))
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, player.CreateGame(&game))
+
+	var created atomic.Int32
+	defer func(original func(context.Context, *obj.Game, uint, string) (*obj.Session, error)) {
+		createGameSession = original
+	}(createGameSession)
+	createGameSession = func(ctx context.Context, game *obj.Game, userId uint, apiKey string) (*obj.Session, error) {
+		created.Add(1)
+		// setting up the assistant takes a while, which is when the double click arrives
+		time.Sleep(50 * time.Millisecond)
+		return &obj.Session{GameID: game.ID, UserID: userId, ThreadID: "thread"}, nil
+	}
+
+	// a double click starts two sessions at the same time
+	sessions := make([]*obj.Session, 2)
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request := router.Request{R: httptest.NewRequest("POST", "/api/session/new", nil), User: player, Ctx: context.Background()}
+			var httpErr *obj.HTTPError
+			sessions[i], httpErr = newSession(request, SessionRequest{GameID: game.ID}, "sk-test", false)
+			assert.Nil(t, httpErr)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), created.Load())
+	if assert.NotNil(t, sessions[0]) && assert.NotNil(t, sessions[1]) {
+		assert.Equal(t, sessions[0].ID, sessions[1].ID)
+	}
+	summaries, httpErr := player.GetGameSessions(game.ID, time.Time{}, 10, 0)
+	assert.Nil(t, httpErr)
+	assert.Len(t, summaries, 1)
+}
+
+func TestNewSessionGivesUpOnHangingSetup(t *testing.T) {
+	initTestDb(t)
+	player := &db.User{Auth0ID: "player"}
+	assert.NoError(t, db.CreateUser(player))
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, player.CreateGame(&game))
+
+	defer func(original func(context.Context, *obj.Game, uint, string) (*obj.Session, error), timeout time.Duration) {
+		createGameSession, sessionSetupTimeout = original, timeout
+	}(createGameSession, sessionSetupTimeout)
+	sessionSetupTimeout = 50 * time.Millisecond
+	createGameSession = func(ctx context.Context, game *obj.Game, userId uint, apiKey string) (*obj.Session, error) {
+		// the provider never answers
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	request := router.Request{R: httptest.NewRequest("POST", "/api/session/new", nil), User: player, Ctx: context.Background()}
+	_, httpErr := newSession(request, SessionRequest{GameID: game.ID}, "sk-test", false)
+	if assert.NotNil(t, httpErr) {
+		assert.Equal(t, http.StatusGatewayTimeout, httpErr.StatusCode)
+	}
+
+	// the lock is released, so the next attempt may set up a session
+	createGameSession = func(ctx context.Context, game *obj.Game, userId uint, apiKey string) (*obj.Session, error) {
+		return &obj.Session{GameID: game.ID, UserID: userId}, nil
+	}
+	session, httpErr := newSession(request, SessionRequest{GameID: game.ID}, "sk-test", false)
+	assert.Nil(t, httpErr)
+	assert.NotNil(t, session)
+}
+
+func TestPlayerActionEndsSessionAtTurnLimit(t *testing.T) {
+	initTestDb(t)
+	player := &db.User{Auth0ID: "player"}
+	assert.NoError(t, db.CreateUser(player))
+	player.UpdateApiKeyPersonal(validApiKey)
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, player.CreateGame(&game))
+	game.MaxTurns = 2
+	assert.NoError(t, player.UpdateGame(game))
+
+	defer func(original func(context.Context, *obj.Session, *obj.Game, obj.GameActionInput, string) (*obj.GameActionOutput, *obj.HTTPError)) {
+		executeAction = original
+	}(executeAction)
+	var actions []obj.GameActionInput
+	executeAction = func(ctx context.Context, session *obj.Session, game *obj.Game, action obj.GameActionInput, apiKey string) (*obj.GameActionOutput, *obj.HTTPError) {
+		actions = append(actions, action)
+		return &obj.GameActionOutput{Type: obj.GameOutputTypeStory, Story: "The door opens."}, nil
+	}
+	play := func(session *obj.Session) (interface{}, *obj.HTTPError) {
+		body := strings.NewReader(`{"action":"` + obj.GameInputTypeAction + `","message":"open the door"}`)
+		request := router.Request{R: httptest.NewRequest("POST", "/api/session/"+session.Hash, body), User: player, Ctx: context.Background()}
+		return handleSessionRequest(request, false)
+	}
+
+	session, err := db.CreateSession(&obj.Session{GameID: game.ID, UserID: player.ID})
+	assert.NoError(t, err)
+	response, httpErr := play(session)
+	assert.Nil(t, httpErr)
+	assert.Empty(t, response.(*obj.GameActionOutput).EndReason)
+	response, httpErr = play(session)
+	assert.Nil(t, httpErr)
+	assert.Equal(t, obj.SessionEndReasonTurnLimit, response.(*obj.GameActionOutput).EndReason)
+	if assert.Len(t, actions, 2) {
+		assert.Equal(t, 1, actions[0].Turn)
+		assert.False(t, actions[0].LastTurn)
+		assert.True(t, actions[1].LastTurn)
+	}
+
+	// the session is over - the assistant isn't asked anymore
+	_, httpErr = play(session)
+	if assert.NotNil(t, httpErr) {
+		assert.Equal(t, http.StatusConflict, httpErr.StatusCode)
+		assert.Equal(t, obj.ErrorCodeSessionEnded, httpErr.ErrorCode())
+	}
+	assert.Len(t, actions, 2)
+
+	// sessions played beyond a lowered limit are ended on their next action
+	other, _ := db.CreateSession(&obj.Session{GameID: game.ID, UserID: player.ID})
+	_, httpErr = play(other)
+	assert.Nil(t, httpErr)
+	game.MaxTurns = 1
+	assert.NoError(t, player.UpdateGame(game))
+	_, httpErr = play(other)
+	if assert.NotNil(t, httpErr) {
+		assert.Equal(t, obj.ErrorCodeSessionEnded, httpErr.ErrorCode())
+	}
+	ended, err := db.GetSessionByHash(other.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, obj.SessionEndReasonTurnLimit, ended.EndReason)
+	assert.Equal(t, 1, ended.Turns)
+	assert.Len(t, actions, 3)
+}
+
+func TestNewPublicSessionRequiresSponsorConsent(t *testing.T) {
+	initTestDb(t)
+	owner := &db.User{Auth0ID: "owner"}
+	assert.NoError(t, db.CreateUser(owner))
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, owner.CreateGame(&game))
+	game.SharePlayActive = true
+	game.SponsorNotice = "Played on the key of the Castle Club"
+	game.SponsorConsentRequired = true
+	assert.NoError(t, owner.UpdateGame(game))
+
+	var created int
+	defer func(original func(context.Context, *obj.Game, uint, string) (*obj.Session, error)) {
+		createGameSession = original
+	}(createGameSession)
+	createGameSession = func(ctx context.Context, game *obj.Game, userId uint, apiKey string) (*obj.Session, error) {
+		created++
+		return &obj.Session{GameID: game.ID, UserID: userId}, nil
+	}
+	request := router.Request{R: httptest.NewRequest("POST", "/api/public/session/new", nil), Ctx: context.Background()}
+
+	_, httpErr := newSession(request, SessionRequest{GameID: game.ID}, "sk-sponsor", true)
+	if assert.NotNil(t, httpErr) {
+		assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+		assert.Equal(t, obj.ErrorCodeSponsorConsentRequired, httpErr.ErrorCode())
+	}
+	assert.Zero(t, created)
+
+	session, httpErr := newSession(request, SessionRequest{GameID: game.ID, SponsorConsent: true}, "sk-sponsor", true)
+	assert.Nil(t, httpErr)
+	assert.Equal(t, 1, created)
+	if assert.NotNil(t, session) {
+		assert.Equal(t, game.SponsorNotice, session.SponsorNotice)
+		assert.Equal(t, obj.ApiKeySourceSponsor, session.ApiKeySource)
+	}
+}
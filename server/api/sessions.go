@@ -0,0 +1,51 @@
+package api
+
+import (
+	"strconv"
+	"time"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+var Sessions = router.NewEndpoint(
+	"/api/sessions",
+	false,
+	"application/json",
+	func(request router.Request) (interface{}, *obj.HTTPError) {
+		if request.User == nil {
+			return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
+		}
+
+		switch request.R.Method {
+		case "DELETE":
+			// DELETE /api/sessions?gameId=&before= deletes the user's own sessions matching the filter
+			query := request.R.URL.Query()
+			var gameId uint64
+			var before time.Time
+			var err error
+			if raw := query.Get("gameId"); raw != "" {
+				if gameId, err = strconv.ParseUint(raw, 10, 32); err != nil {
+					return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - invalid gameId"}
+				}
+			}
+			if raw := query.Get("before"); raw != "" {
+				if before, err = time.Parse(time.RFC3339, raw); err != nil {
+					return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - before must be an RFC3339 timestamp"}
+				}
+			}
+
+			deleted, httpErr := request.User.DeleteSessions(uint(gameId), before)
+			if httpErr != nil {
+				return nil, httpErr
+			}
+			obj.Logf(request.Ctx, "Deleted %d sessions of user %d", deleted, request.User.ID)
+			type SessionsDeleteResponse struct {
+				Deleted int64 `json:"deleted"`
+			}
+			return SessionsDeleteResponse{Deleted: deleted}, nil
+
+		default:
+			return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+		}
+	},
+)
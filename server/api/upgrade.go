@@ -1,7 +1,6 @@
 package api
 
 import (
-	"log"
 	"os"
 	"time"
 	"webapp-server/obj"
@@ -13,10 +12,10 @@ var Upgrade = router.NewEndpoint(
 	true,
 	"application/json",
 	func(request router.Request) (interface{}, *obj.HTTPError) {
-		log.Println("upgrade docker request - exiting server")
+		obj.Logf(request.Ctx, "upgrade docker request - exiting server")
 		go func() {
 			time.Sleep(10 * time.Second)
-			log.Println("shutting down server")
+			obj.Logf(request.Ctx, "shutting down server")
 			os.Exit(0)
 		}()
 		return "Server will shutdown in 10 seconds, upgrade to new version, if available, and then restart. This usually takes 1-3 minutes.", nil
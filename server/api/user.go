@@ -45,11 +45,14 @@ var User = router.NewEndpoint(
 			request.User.Update(postUser.Name, postUser.Email)
 		}
 
-		if postUser.OpenaiKeyPublish == "" || isOpenaiApiKey(postUser.OpenaiKeyPublish) {
+		// new keys are checked against the provider right away, so the client knows whether they work
+		if (postUser.OpenaiKeyPublish == "" || isOpenaiApiKey(postUser.OpenaiKeyPublish)) && postUser.OpenaiKeyPublish != request.User.OpenAiKeyPublish {
 			request.User.UpdateApiKeyPublish(postUser.OpenaiKeyPublish)
+			validateUserApiKey(request, obj.ApiKeyPublish)
 		}
-		if postUser.OpenaiKeyPersonal == "" || isOpenaiApiKey(postUser.OpenaiKeyPersonal) {
+		if (postUser.OpenaiKeyPersonal == "" || isOpenaiApiKey(postUser.OpenaiKeyPersonal)) && postUser.OpenaiKeyPersonal != request.User.OpenAiKeyPersonal {
 			request.User.UpdateApiKeyPersonal(postUser.OpenaiKeyPersonal)
+			validateUserApiKey(request, obj.ApiKeyPersonal)
 		}
 
 		return request.User.Export(), nil
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+	"webapp-server/gpt"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+// UserKeys re-checks the user's api keys against the provider: POST /api/user/keys/{personal|publish}/validate
+// or all of them at once: POST /api/user/keys/validate-all
+var UserKeys = router.NewEndpoint(
+	"/api/user/keys/",
+	false,
+	"application/json",
+	func(request router.Request) (interface{}, *obj.HTTPError) {
+		if request.User == nil {
+			return nil, &obj.HTTPError{StatusCode: 401, Message: "Unauthorized"}
+		}
+		if request.R.Method != "POST" {
+			return nil, &obj.HTTPError{StatusCode: 405, Message: "Method Not Allowed"}
+		}
+		switch path.Base(request.R.URL.Path) {
+		case "validate":
+		case "validate-all":
+			validateAllUserApiKeys(request)
+			return request.User.Export(), nil
+		default:
+			return nil, &obj.HTTPError{StatusCode: 404, Message: "Not Found"}
+		}
+
+		keyType := path.Base(path.Dir(request.R.URL.Path))
+		if keyType != obj.ApiKeyPersonal && keyType != obj.ApiKeyPublish {
+			return nil, &obj.HTTPError{StatusCode: 400, Message: "Bad Request - unknown key type: " + keyType}
+		}
+		if request.User.ApiKey(keyType) == "" {
+			return nil, &obj.HTTPError{StatusCode: 404, Message: "Not Found - no " + keyType + " key set"}
+		}
+
+		validateUserApiKey(request, keyType)
+		return request.User.Export(), nil
+	},
+)
+
+// validateApiKey checks a key against the provider - replaced in tests, which can't reach the provider
+var validateApiKey = gpt.ValidateApiKey
+
+// apiKeyValidationTimeout is how long the provider may take to check a key - keys it doesn't confirm in time are stored as invalid
+var apiKeyValidationTimeout = 10 * time.Second
+
+// validateUserApiKey checks the user's key of the given type against the provider and stores the result
+func validateUserApiKey(request router.Request, keyType string) {
+	apiKey := request.User.ApiKey(keyType)
+	if apiKey == "" {
+		return
+	}
+	request.User.SetApiKeyStatus(keyType, checkApiKey(request, keyType, apiKey))
+}
+
+// validateAllUserApiKeys checks all keys of the user concurrently - a key used for both purposes is checked only once.
+// Each check is bounded by apiKeyValidationTimeout, so waiting for them ends.
+func validateAllUserApiKeys(request router.Request) {
+	keyTypes := map[string]string{}
+	for _, keyType := range []string{obj.ApiKeyPersonal, obj.ApiKeyPublish} {
+		if apiKey := request.User.ApiKey(keyType); apiKey != "" && keyTypes[apiKey] == "" {
+			keyTypes[apiKey] = keyType
+		}
+	}
+
+	valid := make(map[string]bool, len(keyTypes))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for apiKey, keyType := range keyTypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := checkApiKey(request, keyType, apiKey)
+			mutex.Lock()
+			valid[apiKey] = result
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// the results are stored one after the other, as each of them saves the user
+	for _, keyType := range []string{obj.ApiKeyPersonal, obj.ApiKeyPublish} {
+		if apiKey := request.User.ApiKey(keyType); apiKey != "" {
+			request.User.SetApiKeyStatus(keyType, valid[apiKey])
+		}
+	}
+}
+
+func checkApiKey(request router.Request, keyType, apiKey string) bool {
+	ctx, cancel := context.WithTimeout(request.Ctx, apiKeyValidationTimeout)
+	defer cancel()
+	err := validateApiKey(ctx, apiKey)
+	if err != nil {
+		obj.Logf(request.Ctx, "The %s api key of user %d failed validation: %s", keyType, request.User.ID, err.Error())
+	}
+	return err == nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"webapp-server/db"
+	"webapp-server/obj"
+	"webapp-server/router"
+)
+
+const (
+	validApiKey   = "sk-cLoIdjNmT0qkZizSrzZ222BlbkFJKFSqlHsO9AsRO6LU88Qy"
+	invalidApiKey = "sk-AAAAdjNmT0qkZizSrzZ222BlbkFJKFSqlHsO9AsRO6LUAAAA"
+	hangingApiKey = "sk-BBBBdjNmT0qkZizSrzZ222BlbkFJKFSqlHsO9AsRO6LUBBBB"
+)
+
+// stubValidateApiKey replaces the provider check for the test: validApiKey passes, hangingApiKey never gets an answer.
+// It returns how often each key was checked.
+func stubValidateApiKey(t *testing.T) map[string]int {
+	original, timeout := validateApiKey, apiKeyValidationTimeout
+	t.Cleanup(func() { validateApiKey, apiKeyValidationTimeout = original, timeout })
+	apiKeyValidationTimeout = 50 * time.Millisecond
+	calls := map[string]int{}
+	var mutex sync.Mutex
+	validateApiKey = func(ctx context.Context, apiKey string) error {
+		mutex.Lock()
+		calls[apiKey]++
+		mutex.Unlock()
+		switch apiKey {
+		case validApiKey:
+			return nil
+		case hangingApiKey:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return fmt.Errorf("invalid api key")
+	}
+	return calls
+}
+
+func TestValidateUserApiKey(t *testing.T) {
+	initTestDb(t)
+	stubValidateApiKey(t)
+	user := &db.User{Auth0ID: "user"}
+	assert.NoError(t, db.CreateUser(user))
+	request := router.Request{R: httptest.NewRequest("POST", "/api/user", nil), User: user, Ctx: context.Background()}
+
+	for apiKey, valid := range map[string]bool{validApiKey: true, invalidApiKey: false, hangingApiKey: false} {
+		user.UpdateApiKeyPersonal(apiKey)
+		validateUserApiKey(request, obj.ApiKeyPersonal)
+
+		stored, err := db.GetUserByID(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, valid, stored.OpenAiKeyPersonalValid, apiKey)
+		assert.NotNil(t, stored.OpenAiKeyPersonalValidatedAt, apiKey)
+		assert.Nil(t, stored.OpenAiKeyPublishValidatedAt, apiKey)
+	}
+}
+
+func TestValidateAllUserApiKeys(t *testing.T) {
+	initTestDb(t)
+	calls := stubValidateApiKey(t)
+	shared := &db.User{Auth0ID: "shared"}
+	mixed := &db.User{Auth0ID: "mixed"}
+	assert.NoError(t, db.CreateUser(shared))
+	assert.NoError(t, db.CreateUser(mixed))
+	shared.UpdateApiKeyPersonal(validApiKey)
+	shared.UpdateApiKeyPublish(validApiKey)
+	mixed.UpdateApiKeyPersonal(invalidApiKey)
+	mixed.UpdateApiKeyPublish(hangingApiKey)
+
+	for _, user := range []*db.User{shared, mixed} {
+		validateAllUserApiKeys(router.Request{R: httptest.NewRequest("POST", "/api/user/keys/validate-all", nil), User: user, Ctx: context.Background()})
+	}
+
+	// a key used for both purposes is checked once, but its status is stored for both
+	assert.Equal(t, map[string]int{validApiKey: 1, invalidApiKey: 1, hangingApiKey: 1}, calls)
+	stored, err := db.GetUserByID(shared.ID)
+	assert.NoError(t, err)
+	assert.True(t, stored.OpenAiKeyPersonalValid)
+	assert.NotNil(t, stored.OpenAiKeyPersonalValidatedAt)
+	assert.True(t, stored.OpenAiKeyPublishValid)
+	assert.NotNil(t, stored.OpenAiKeyPublishValidatedAt)
+
+	// keys the provider doesn't confirm in time count as invalid
+	stored, err = db.GetUserByID(mixed.ID)
+	assert.NoError(t, err)
+	assert.False(t, stored.OpenAiKeyPersonalValid)
+	assert.NotNil(t, stored.OpenAiKeyPersonalValidatedAt)
+	assert.False(t, stored.OpenAiKeyPublishValid)
+	assert.NotNil(t, stored.OpenAiKeyPublishValidatedAt)
+}
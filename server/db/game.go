@@ -6,24 +6,34 @@ import (
 	"encoding/json"
 	"gorm.io/gorm"
 	"strings"
+	"time"
 	"webapp-server/obj"
 )
 
 type Game struct {
 	gorm.Model
-	Title               string `json:"title"`
-	TitleImage          []byte
-	Description         string `json:"description"`
-	Scenario            string `json:"scenario"`
-	SessionStartSyscall string `json:"sessionStartSyscall"`
-	ImageStyle          string `json:"imageStyle"`
-	StatusFields        string `json:"statusProperties"`
-	SharePlayActive     bool   `json:"sharePlayActive"`
-	SharePlayHash       string `json:"sharePlayHash"`
-	ShareEditActive     bool   `json:"shareEditActive"`
-	ShareEditHash       string `json:"shareEditHash"`
-	UserID              uint   `json:"-"`
-	User                User   `json:"user" gorm:"foreignKey:UserID"`
+	Title                  string `json:"title"`
+	TitleImage             []byte
+	Description            string   `json:"description"`
+	Scenario               string   `json:"scenario"`
+	SessionStartSyscall    string   `json:"sessionStartSyscall"`
+	ImageStyle             string   `json:"imageStyle"`
+	StatusFields           string   `json:"statusProperties"`
+	Tags                   string   `json:"tags" gorm:"not null;default:''"` // comma separated, with a leading and trailing comma for matching single tags
+	Temperature            *float32 `json:"temperature"`
+	TopP                   *float32 `json:"topP"`
+	MaxOutputTokens        int      `json:"maxOutputTokens"`
+	MinTurns               int      `json:"minTurns"`
+	MaxTurns               int      `json:"maxTurns"`
+	SharePlayActive        bool     `json:"sharePlayActive"`
+	SharePlayHash          string   `json:"sharePlayHash"`
+	SponsorNotice          string   `json:"sponsorNotice"`
+	SponsorConsentRequired bool     `json:"sponsorConsentRequired"`
+	PlayCount              int      `json:"playCount" gorm:"not null;default:0"`
+	ShareEditActive        bool     `json:"shareEditActive"`
+	ShareEditHash          string   `json:"shareEditHash"`
+	UserID                 uint     `json:"-"`
+	User                   User     `json:"user" gorm:"foreignKey:UserID"`
 }
 
 // CreateGame creates a new game in the database
@@ -47,12 +57,28 @@ func GetGameByPublicHash(hash string) (*obj.Game, *obj.HTTPError) {
 	return game.Export(), nil
 }
 
+// GetTitleImageByPublicHash returns the cover image of a game shared for play, or nil if it has none
+func GetTitleImageByPublicHash(hash string) ([]byte, *obj.HTTPError) {
+	var game Game
+	err := db.Select("title_image").Where("share_play_hash = ?", hash).Where("share_play_active = ?", true).First(&game).Error
+	if err != nil {
+		return nil, &obj.HTTPError{StatusCode: 404, Message: "Game not found"}
+	}
+	return game.TitleImage, nil
+}
+
 func (game *Game) update() error {
 	game.SharePlayHash = strings.TrimSpace(game.SharePlayHash)
 	if game.SharePlayHash == "" {
 		game.SharePlayHash = randomHash()
 	}
-	return db.Save(game).Error
+	// the play count is only ever changed by IncrementPlayCount, saving a stale value would lose plays
+	return db.Omit("play_count").Save(game).Error
+}
+
+// IncrementPlayCount counts a new session of the game - atomically, as many players may start at once
+func IncrementPlayCount(gameId uint) error {
+	return db.Model(&Game{}).Where("id = ?", gameId).UpdateColumn("play_count", gorm.Expr("play_count + 1")).Error
 }
 
 func (game *Game) Export() *obj.Game {
@@ -60,21 +86,62 @@ func (game *Game) Export() *obj.Game {
 	if err := json.Unmarshal([]byte(game.StatusFields), &statusFields); err != nil {
 		statusFields = []obj.StatusField{}
 	}
+	var deletedAt *time.Time
+	if game.DeletedAt.Valid {
+		deletedAt = &game.DeletedAt.Time
+	}
 	return &obj.Game{
-		ID:                  game.ID,
-		Title:               game.Title,
-		Description:         game.Description,
-		Scenario:            game.Scenario,
-		SessionStartSyscall: game.SessionStartSyscall,
-		StatusFields:        statusFields,
-		ImageStyle:          game.ImageStyle,
-		SharePlayActive:     game.SharePlayActive,
-		SharePlayHash:       game.SharePlayHash,
-		ShareEditActive:     game.ShareEditActive,
-		ShareEditHash:       game.ShareEditHash,
-		UserId:              game.UserID,
-		UserName:            game.User.Name,
+		ID:                     game.ID,
+		Title:                  game.Title,
+		HasTitleImage:          len(game.TitleImage) > 0,
+		Description:            game.Description,
+		Scenario:               game.Scenario,
+		SessionStartSyscall:    game.SessionStartSyscall,
+		StatusFields:           statusFields,
+		Tags:                   splitTags(game.Tags),
+		ImageStyle:             game.ImageStyle,
+		Temperature:            game.Temperature,
+		TopP:                   game.TopP,
+		MaxOutputTokens:        game.MaxOutputTokens,
+		MinTurns:               game.MinTurns,
+		MaxTurns:               game.MaxTurns,
+		SharePlayActive:        game.SharePlayActive,
+		SharePlayHash:          game.SharePlayHash,
+		SponsorNotice:          game.SponsorNotice,
+		SponsorConsentRequired: game.SponsorConsentRequired,
+		PlayCount:              game.PlayCount,
+		ShareEditActive:        game.ShareEditActive,
+		ShareEditHash:          game.ShareEditHash,
+		UserId:                 game.UserID,
+		UserName:               game.User.Name,
+		DeletedAt:              deletedAt,
+	}
+}
+
+// joinTags stores tags lowercased and without duplicates as ",tag1,tag2," - so a single tag matches "%,tag,%"
+func joinTags(tags []string) string {
+	var joined []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		joined = append(joined, tag)
+	}
+	if len(joined) == 0 {
+		return ""
+	}
+	return "," + strings.Join(joined, ",") + ","
+}
+
+func splitTags(tags string) []string {
+	tags = strings.Trim(tags, ",")
+	if tags == "" {
+		return []string{}
 	}
+	return strings.Split(tags, ",")
 }
 
 func randomHash() string {
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"path"
@@ -10,18 +11,36 @@ var db *gorm.DB
 
 func Init() {
 	pathDb := path.Join("var", "sqlite.db")
+	if err := Open(pathDb); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Open connects to the sqlite database at pathDb and migrates its schema
+func Open(pathDb string) error {
 	var err error
 	db, err = gorm.Open(sqlite.Open(pathDb), &gorm.Config{})
 	if err != nil {
-		panic("failed to connect database '" + pathDb + "': " + err.Error())
+		return fmt.Errorf("failed to connect database '%s': %w", pathDb, err)
+	}
+	if err = migrate(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
+	return nil
+}
 
-	// Migrate the schema
+func migrate() error {
 	tables := []interface{}{&User{}, &Game{}, &Session{}, &Chapter{}}
 	for _, table := range tables {
-		err = db.AutoMigrate(table)
-		if err != nil {
-			panic("failed to migrate database: " + err.Error())
+		if err := db.AutoMigrate(table); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// Ping checks whether the database is reachable and answers queries
+func Ping() error {
+	var result int
+	return db.Raw("SELECT 1").Scan(&result).Error
 }
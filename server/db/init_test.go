@@ -0,0 +1,18 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path"
+	"testing"
+)
+
+func initTestDb(t *testing.T) {
+	if err := Open(path.Join(t.TempDir(), "sqlite.db")); err != nil {
+		t.Fatalf("failed opening test database: %s", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	initTestDb(t)
+	assert.NoError(t, Ping())
+}
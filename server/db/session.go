@@ -1,8 +1,12 @@
 package db
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"gorm.io/gorm"
 	"net/http"
+	"time"
 	"webapp-server/lang"
 	"webapp-server/obj"
 )
@@ -17,8 +21,14 @@ type Session struct {
 	AssistantInstructions string
 	ThreadID              string
 	Hash                  string
+	ApiKeySource          string
+	Turns                 int `gorm:"not null;default:0"` // player actions played so far
+	EndReason             string
 }
 
+// userAnonymous is the user id of sessions played via a public link without login
+const userAnonymous = uint(0)
+
 type Chapter struct {
 	gorm.Model
 	SessionID   uint
@@ -28,6 +38,7 @@ type Chapter struct {
 	Output      string
 	ImagePrompt string
 	Image       []byte
+	ImageError  string // set, if the image couldn't be generated - the chapter stays text-only
 }
 
 func (session *Session) export() *obj.Session {
@@ -39,6 +50,10 @@ func (session *Session) export() *obj.Session {
 		AssistantInstructions: session.AssistantInstructions,
 		ThreadID:              session.ThreadID,
 		Hash:                  session.Hash,
+		CreatedAt:             session.CreatedAt,
+		ApiKeySource:          session.ApiKeySource,
+		Turns:                 session.Turns,
+		EndReason:             session.EndReason,
 	}
 }
 
@@ -50,6 +65,8 @@ func (chapter *Chapter) export() *obj.Chapter {
 		Output:      chapter.Output,
 		ImagePrompt: chapter.ImagePrompt,
 		Image:       chapter.Image,
+		ImageError:  chapter.ImageError,
+		CreatedAt:   chapter.CreatedAt,
 	}
 }
 
@@ -59,6 +76,19 @@ func GetSessionByHash(hash string) (*obj.Session, error) {
 	return session.export(), err
 }
 
+// GetFreshSession returns the newest session of a user for a game, which was created after since and has not been played yet.
+// If there is no such session, nil is returned.
+func GetFreshSession(userId, gameId uint, since time.Time) (*obj.Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND game_id = ? AND created_at >= ?", userId, gameId, since).
+		Where("NOT EXISTS (SELECT 1 FROM chapters WHERE chapters.session_id = sessions.id AND chapters.deleted_at IS NULL)").
+		Order("created_at desc").Limit(1).Find(&sessions).Error
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return sessions[0].export(), nil
+}
+
 func CreateSession(session *obj.Session) (*obj.Session, error) {
 	userId := session.UserID
 	sessionDb := Session{
@@ -68,6 +98,7 @@ func CreateSession(session *obj.Session) (*obj.Session, error) {
 		AssistantInstructions: session.AssistantInstructions,
 		ThreadID:              session.ThreadID,
 		Hash:                  generateHash(),
+		ApiKeySource:          session.ApiKeySource,
 	}
 	err := db.Create(&sessionDb).Error
 	return sessionDb.export(), err
@@ -89,6 +120,20 @@ func AddChapter(sessionId, chapterId uint, input, output, imagePrompt string) (*
 	return &chapterDb, nil
 }
 
+// RecordTurn counts a player action of the session and ends the session with it, if an end reason is given
+func RecordTurn(sessionId uint, endReason string) error {
+	updates := map[string]interface{}{"turns": gorm.Expr("turns + 1")}
+	if endReason != "" {
+		updates["end_reason"] = endReason
+	}
+	return db.Model(&Session{}).Where("id = ?", sessionId).Updates(updates).Error
+}
+
+// EndSession marks a session as ended, so that it accepts no more player actions
+func EndSession(sessionId uint, reason string) error {
+	return db.Model(&Session{}).Where("id = ?", sessionId).Update("end_reason", reason).Error
+}
+
 func GetChapter(sessionId, chapterId uint) (*obj.Chapter, error) {
 	var chapter Chapter
 	err := db.Where("session_id = ? AND chapter = ?", sessionId, chapterId).First(&chapter).Error
@@ -98,6 +143,19 @@ func GetChapter(sessionId, chapterId uint) (*obj.Chapter, error) {
 	return chapter.export(), nil
 }
 
+// GetChapters returns all chapters of a session in order, without their images
+func GetChapters(sessionId uint) ([]obj.Chapter, error) {
+	var chapters []Chapter
+	if err := db.Omit("Image").Where("session_id = ?", sessionId).Order("chapter").Find(&chapters).Error; err != nil {
+		return nil, err
+	}
+	chaptersObj := make([]obj.Chapter, len(chapters))
+	for i := range chapters {
+		chaptersObj[i] = *chapters[i].export()
+	}
+	return chaptersObj, nil
+}
+
 func SetImage(sessionId, chapterId uint, image []byte) *obj.HTTPError {
 	var chapter Chapter
 	err := db.Where("session_id = ? AND chapter = ?", sessionId, chapterId).First(&chapter).Error
@@ -110,3 +168,124 @@ func SetImage(sessionId, chapterId uint, image []byte) *obj.HTTPError {
 	}
 	return nil
 }
+
+// GetGameSessions lists the sessions of all players of a game, newest first. Only the owner of the game may do this.
+func (user *User) GetGameSessions(gameId uint, since time.Time, limit, offset int) ([]obj.SessionSummary, *obj.HTTPError) {
+	if _, httpErr := user.getGame(gameId); httpErr != nil {
+		return nil, httpErr
+	}
+
+	var sessions []Session
+	query := db.Where("game_id = ?", gameId)
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	if err := query.Order("created_at desc").Limit(limit).Offset(offset).Find(&sessions).Error; err != nil {
+		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+
+	sessionIds := make([]uint, len(sessions))
+	for i := range sessions {
+		sessionIds[i] = sessions[i].ID
+	}
+	var counts []struct {
+		SessionID uint
+		Count     int64
+	}
+	if err := db.Model(&Chapter{}).Select("session_id, count(*) as count").Where("session_id IN ?", sessionIds).Group("session_id").Scan(&counts).Error; err != nil {
+		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+	chapters := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		chapters[count.SessionID] = count.Count
+	}
+	var failedImages []struct {
+		SessionID  uint
+		Chapter    uint
+		ImageError string
+	}
+	if err := db.Model(&Chapter{}).Select("session_id, chapter, image_error").Where("session_id IN ? AND image_error <> ''", sessionIds).Order("chapter").Scan(&failedImages).Error; err != nil {
+		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+	imageErrors := make(map[uint][]obj.ChapterImageError)
+	for _, failed := range failedImages {
+		imageErrors[failed.SessionID] = append(imageErrors[failed.SessionID], obj.ChapterImageError{Chapter: failed.Chapter, Error: failed.ImageError})
+	}
+
+	summaries := make([]obj.SessionSummary, len(sessions))
+	for i, session := range sessions {
+		var playerId uint
+		if session.UserID != nil {
+			playerId = *session.UserID
+		}
+		summaries[i] = obj.SessionSummary{
+			ID:           session.ID,
+			GameID:       session.GameID,
+			Player:       user.anonymizedPlayer(gameId, playerId),
+			Chapters:     chapters[session.ID],
+			ApiKeySource: session.ApiKeySource,
+			CreatedAt:    session.CreatedAt,
+			UpdatedAt:    session.UpdatedAt,
+			ImageErrors:  imageErrors[session.ID],
+		}
+	}
+	return summaries, nil
+}
+
+// playerSalt keeps anonymized player labels from being reversed by enumerating user ids
+var playerSalt = generateHash()
+
+// anonymizedPlayer returns a label for a player of a game, which is stable while the server is running,
+// but doesn't reveal the player's identity to the game owner
+func (user *User) anonymizedPlayer(gameId, playerId uint) string {
+	switch playerId {
+	case userAnonymous:
+		return "anonymous"
+	case user.ID:
+		return user.Name
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d", playerSalt, gameId, playerId)))
+	return "player_" + hex.EncodeToString(hash[:])[:8]
+}
+
+// DeleteSessions deletes the user's own sessions including their chapters and returns how many were deleted.
+// If gameId is set, only sessions of that game are deleted, if before is set, only sessions created before that time.
+func (user *User) DeleteSessions(gameId uint, before time.Time) (int64, *obj.HTTPError) {
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Session{}).Where("user_id = ?", user.ID)
+		if gameId > 0 {
+			query = query.Where("game_id = ?", gameId)
+		}
+		if !before.IsZero() {
+			query = query.Where("created_at < ?", before)
+		}
+		var sessionIds []uint
+		if err := query.Pluck("id", &sessionIds).Error; err != nil {
+			return err
+		}
+		if len(sessionIds) == 0 {
+			return nil
+		}
+		// the user asked to clear their history - transcripts and images must not stay behind soft-deleted
+		if err := tx.Unscoped().Where("session_id IN ?", sessionIds).Delete(&Chapter{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("id IN ?", sessionIds).Delete(&Session{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+	return deleted, nil
+}
+
+// SetImageFailed marks the chapter as text-only, because its image couldn't be generated
+func SetImageFailed(sessionId, chapterId uint, reason string) *obj.HTTPError {
+	err := db.Model(&Chapter{}).Where("session_id = ? AND chapter = ?", sessionId, chapterId).Update("image_error", reason).Error
+	if err != nil {
+		return &obj.HTTPError{StatusCode: http.StatusInternalServerError, Message: lang.ErrorFailedUpdatingGameData}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+	"webapp-server/obj"
+)
+
+func TestGetFreshSession(t *testing.T) {
+	initTestDb(t)
+	start := time.Now().Add(-time.Second)
+
+	created, err := CreateSession(&obj.Session{GameID: 1, UserID: 7})
+	assert.NoError(t, err)
+
+	// a second create within the window finds the first session
+	fresh, err := GetFreshSession(7, 1, start)
+	assert.NoError(t, err)
+	assert.NotNil(t, fresh)
+	assert.Equal(t, created.ID, fresh.ID)
+
+	// other users, other games and older sessions don't match
+	fresh, err = GetFreshSession(8, 1, start)
+	assert.NoError(t, err)
+	assert.Nil(t, fresh)
+	fresh, err = GetFreshSession(7, 2, start)
+	assert.NoError(t, err)
+	assert.Nil(t, fresh)
+	fresh, err = GetFreshSession(7, 1, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, fresh)
+
+	// once played, the session isn't fresh anymore
+	_, err = AddChapter(created.ID, 1, "input", "output", "image")
+	assert.NoError(t, err)
+	fresh, err = GetFreshSession(7, 1, start)
+	assert.NoError(t, err)
+	assert.Nil(t, fresh)
+}
+
+func TestDeleteSessions(t *testing.T) {
+	initTestDb(t)
+	user := &User{}
+	assert.NoError(t, CreateUser(user))
+
+	first, _ := CreateSession(&obj.Session{GameID: 1, UserID: user.ID})
+	_, _ = CreateSession(&obj.Session{GameID: 2, UserID: user.ID})
+	other, _ := CreateSession(&obj.Session{GameID: 1, UserID: user.ID + 1})
+	_, _ = AddChapter(first.ID, 1, "input", "output", "image")
+
+	deleted, httpErr := user.DeleteSessions(1, time.Time{})
+	assert.Nil(t, httpErr)
+	assert.Equal(t, int64(1), deleted)
+	_, err := GetChapter(first.ID, 1)
+	assert.Error(t, err)
+	var remaining int64
+	db.Unscoped().Model(&Chapter{}).Where("session_id = ?", first.ID).Count(&remaining)
+	assert.Zero(t, remaining)
+	db.Unscoped().Model(&Session{}).Where("id = ?", first.ID).Count(&remaining)
+	assert.Zero(t, remaining)
+
+	// sessions of other users are never touched
+	deleted, httpErr = user.DeleteSessions(0, time.Time{})
+	assert.Nil(t, httpErr)
+	assert.Equal(t, int64(1), deleted)
+	_, err = GetSessionByHash(other.Hash)
+	assert.NoError(t, err)
+}
+
+func TestRecordTurnAndEndSession(t *testing.T) {
+	initTestDb(t)
+	session, err := CreateSession(&obj.Session{GameID: 1, UserID: 7})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, RecordTurn(session.ID, ""))
+		}()
+	}
+	wg.Wait()
+	played, err := GetSessionByHash(session.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, played.Turns)
+	assert.Empty(t, played.EndReason)
+
+	// the last turn ends the session
+	assert.NoError(t, RecordTurn(session.ID, obj.SessionEndReasonTurnLimit))
+	ended, err := GetSessionByHash(session.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, ended.Turns)
+	assert.Equal(t, obj.SessionEndReasonTurnLimit, ended.EndReason)
+
+	// sessions played before the limit was lowered are ended without a turn
+	other, _ := CreateSession(&obj.Session{GameID: 1, UserID: 7})
+	assert.NoError(t, EndSession(other.ID, obj.SessionEndReasonTurnLimit))
+	ended, _ = GetSessionByHash(other.Hash)
+	assert.Equal(t, 0, ended.Turns)
+	assert.Equal(t, obj.SessionEndReasonTurnLimit, ended.EndReason)
+}
+
+func TestGetGameSessions(t *testing.T) {
+	initTestDb(t)
+	owner := &User{Auth0ID: "owner", Name: "owner"}
+	player := &User{Auth0ID: "auth0|5f3a"}
+	assert.NoError(t, CreateUser(owner))
+	assert.NoError(t, CreateUser(player))
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, owner.CreateGame(&game))
+
+	ownSession, _ := CreateSession(&obj.Session{GameID: game.ID, UserID: owner.ID})
+	anonymousSession, _ := CreateSession(&obj.Session{GameID: game.ID, UserID: userAnonymous})
+	playerSession, _ := CreateSession(&obj.Session{GameID: game.ID, UserID: player.ID})
+	_, _ = CreateSession(&obj.Session{GameID: game.ID + 1, UserID: player.ID})
+	_, _ = AddChapter(playerSession.ID, 1, "input", "output", "image")
+	_, _ = AddChapter(playerSession.ID, 2, "input", "output", "image")
+	assert.Nil(t, SetImageFailed(playerSession.ID, 2, "Image creation error: content policy violation"))
+	now := time.Now()
+	db.Model(&Session{}).Where("id = ?", ownSession.ID).Update("created_at", now.Add(-3*time.Hour))
+	db.Model(&Session{}).Where("id = ?", anonymousSession.ID).Update("created_at", now.Add(-2*time.Hour))
+	db.Model(&Session{}).Where("id = ?", playerSession.ID).Update("created_at", now.Add(-time.Hour))
+
+	// only the owner of the game may list its sessions
+	_, httpErr := player.GetGameSessions(game.ID, time.Time{}, 10, 0)
+	assert.NotNil(t, httpErr)
+
+	sessions, httpErr := owner.GetGameSessions(game.ID, time.Time{}, 10, 0)
+	assert.Nil(t, httpErr)
+	if assert.Len(t, sessions, 3) {
+		assert.Equal(t, playerSession.ID, sessions[0].ID)
+		assert.Regexp(t, `^player_[0-9a-f]{8}$`, sessions[0].Player)
+		assert.Equal(t, int64(2), sessions[0].Chapters)
+		assert.Equal(t, []obj.ChapterImageError{{Chapter: 2, Error: "Image creation error: content policy violation"}}, sessions[0].ImageErrors)
+		assert.Empty(t, sessions[1].ImageErrors)
+		assert.Equal(t, "anonymous", sessions[1].Player)
+		assert.Equal(t, int64(0), sessions[1].Chapters)
+		assert.Equal(t, "owner", sessions[2].Player)
+	}
+	// the label is stable, but reveals neither the player nor their session hash
+	again, _ := owner.GetGameSessions(game.ID, time.Time{}, 1, 0)
+	if assert.Len(t, again, 1) {
+		assert.Equal(t, sessions[0].Player, again[0].Player)
+		assert.NotContains(t, again[0].Player, playerSession.Hash)
+		assert.NotContains(t, again[0].Player, player.Auth0ID)
+	}
+
+	sessions, _ = owner.GetGameSessions(game.ID, now.Add(-150*time.Minute), 10, 0)
+	assert.Len(t, sessions, 2)
+	sessions, _ = owner.GetGameSessions(game.ID, time.Time{}, 1, 1)
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, anonymousSession.ID, sessions[0].ID)
+	}
+}
@@ -1,11 +1,14 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
-	"log"
 	"net/http"
+	"strings"
+	"time"
 	"webapp-server/obj"
 )
 
@@ -17,6 +20,11 @@ type User struct {
 	OpenAiKeyPublish  string `json:"openaiKeyPublish"`
 	OpenAiKeyPersonal string `json:"openaiKeyPersonal"`
 	Games             []Game
+	// result of the last check of the keys against the provider
+	OpenAiKeyPublishValid        bool
+	OpenAiKeyPublishValidatedAt  *time.Time
+	OpenAiKeyPersonalValid       bool
+	OpenAiKeyPersonalValidatedAt *time.Time
 }
 
 // CreateUser creates a new user in the database
@@ -43,10 +51,56 @@ func DeleteUser(id uint) error {
 	return db.Delete(&User{}, id).Error
 }
 
-func (user *User) GetGames() ([]obj.Game, *obj.HTTPError) {
+// gameSortColumns maps the sort options of the game list to columns
+var gameSortColumns = map[string]string{
+	obj.GameSortTitle:     "games.title",
+	obj.GameSortCreatedAt: "games.created_at",
+	obj.GameSortPlayCount: "games.play_count",
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, which are matched with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListGames lists the games visible to the user - their own ones and those shared for play - filtered by the options.
+// The total counts all games matching the filters, regardless of the page.
+func (user *User) ListGames(options obj.GameListOptions) (*obj.GameList, *obj.HTTPError) {
+	sortColumn, ok := gameSortColumns[options.Sort]
+	if !ok {
+		return nil, &obj.HTTPError{StatusCode: http.StatusBadRequest, Message: "Bad Request - unknown sort: " + options.Sort}
+	}
+
+	query := db.Preload("User").Where("games.user_id = ? OR games.share_play_active = ?", user.ID, true)
+	if options.Search != "" {
+		pattern := "%" + likeEscaper.Replace(options.Search) + "%"
+		query = query.Where(`games.title LIKE ? ESCAPE '\' OR games.description LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+	if options.Mine != nil {
+		if *options.Mine {
+			query = query.Where("games.user_id = ?", user.ID)
+		} else {
+			query = query.Where("games.user_id <> ?", user.ID)
+		}
+	}
+	if options.Public != nil {
+		query = query.Where("games.share_play_active = ?", *options.Public)
+	}
+	if tag := joinTags([]string{options.Tag}); tag != "" {
+		query = query.Where(`games.tags LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(tag)+"%")
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Model(&Game{}).Count(&total).Error; err != nil {
+		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if options.Descending {
+		sortColumn += " DESC"
+	}
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+
 	var games []Game
-	err := db.Preload("User").Model(&user).Association("Games").Find(&games)
-	if err != nil {
+	if err := query.Order(sortColumn).Order("games.id").Offset(options.Offset).Find(&games).Error; err != nil {
 		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
 	}
 	gamesObj := make([]obj.Game, len(games))
@@ -56,17 +110,52 @@ func (user *User) GetGames() ([]obj.Game, *obj.HTTPError) {
 		}
 		gamesObj[i] = *games[i].Export()
 	}
+	return &obj.GameList{Games: gamesObj, Total: total}, nil
+}
+
+// GetRecentGames returns the games the user played most recently, latest first.
+// Games the user can't play anymore - deleted or no longer shared - are left out.
+func (user *User) GetRecentGames(limit int) ([]obj.Game, *obj.HTTPError) {
+	var gameIds []uint
+	err := db.Table("sessions").
+		Select("sessions.game_id").
+		Joins("JOIN games ON games.id = sessions.game_id AND games.deleted_at IS NULL").
+		Joins("LEFT JOIN chapters ON chapters.session_id = sessions.id AND chapters.deleted_at IS NULL").
+		Where("sessions.user_id = ? AND sessions.deleted_at IS NULL", user.ID).
+		Where("games.user_id = ? OR games.share_play_active = ?", user.ID, true).
+		Group("sessions.game_id").
+		Order("MAX(COALESCE(chapters.created_at, sessions.created_at)) DESC").
+		Limit(limit).
+		Pluck("sessions.game_id", &gameIds).Error
+	if err != nil {
+		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+
+	var games []Game
+	if err = db.Preload("User").Where("id IN ?", gameIds).Find(&games).Error; err != nil {
+		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+	gamesById := make(map[uint]*Game, len(games))
+	for i := range games {
+		gamesById[games[i].ID] = &games[i]
+	}
+	gamesObj := make([]obj.Game, 0, len(gameIds))
+	for _, id := range gameIds {
+		if game, ok := gamesById[id]; ok {
+			gamesObj = append(gamesObj, *game.Export())
+		}
+	}
 	return gamesObj, nil
 }
 
 // GetGame gets a game by ID, formatted for external use
-func (user *User) GetGame(id uint) (*obj.Game, *obj.HTTPError) {
-	log.Printf("Getting game %d from db", id)
+func (user *User) GetGame(ctx context.Context, id uint) (*obj.Game, *obj.HTTPError) {
+	obj.Logf(ctx, "Getting game %d from db", id)
 	game, err := user.getGame(id)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("Got game %d from db", id)
+	obj.Logf(ctx, "Got game %d from db", id)
 	return game.Export(), nil
 }
 
@@ -74,6 +163,9 @@ func (user *User) GetGame(id uint) (*obj.Game, *obj.HTTPError) {
 func (user *User) getGame(id uint) (*Game, *obj.HTTPError) {
 	var game Game
 	err := db.Preload("User").Where("id = ?", id).First(&game).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, obj.NewHTTPErrorf(http.StatusNotFound, "game not found")
+	}
 	if err != nil {
 		return nil, obj.ErrorToHTTPError(http.StatusInternalServerError, err)
 	}
@@ -102,6 +194,38 @@ func (user *User) DeleteGame(gameId uint) *obj.HTTPError {
 	return nil
 }
 
+// gameRestoreWindow is how long a deleted game can be restored by its owner
+const gameRestoreWindow = 30 * 24 * time.Hour
+
+// GetGameIncludingDeleted gets a game by ID like GetGame, but also finds games which were deleted
+func (user *User) GetGameIncludingDeleted(id uint) (*obj.Game, *obj.HTTPError) {
+	var game Game
+	err := db.Unscoped().Preload("User").Where("id = ? AND user_id = ?", id, user.ID).First(&game).Error
+	if err != nil {
+		return nil, obj.NewHTTPErrorf(http.StatusNotFound, "game not found")
+	}
+	return game.Export(), nil
+}
+
+// RestoreGame undoes the deletion of a game, if it was deleted within the restore window
+func (user *User) RestoreGame(gameId uint) *obj.HTTPError {
+	var game Game
+	err := db.Unscoped().Where("id = ? AND user_id = ?", gameId, user.ID).First(&game).Error
+	if err != nil {
+		return obj.NewHTTPErrorf(http.StatusNotFound, "game not found")
+	}
+	if !game.DeletedAt.Valid {
+		return obj.NewHTTPErrorf(http.StatusBadRequest, "game is not deleted")
+	}
+	if time.Since(game.DeletedAt.Time) > gameRestoreWindow {
+		return obj.NewHTTPErrorf(http.StatusGone, "game was deleted more than %d days ago and can't be restored", int(gameRestoreWindow.Hours()/24))
+	}
+	if err = db.Unscoped().Model(&game).Update("deleted_at", nil).Error; err != nil {
+		return obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
 func (user *User) CreateGame(game *obj.Game) error {
 	statusFieldsSerialized, _ := json.Marshal(game.StatusFields)
 	gameDb := &Game{
@@ -120,6 +244,32 @@ func (user *User) CreateGame(game *obj.Game) error {
 	return nil
 }
 
+// ImportGame creates a new game owned by the user with all content of the given game - ids and share settings are not taken over
+func (user *User) ImportGame(game *obj.Game) error {
+	statusFieldsSerialized, _ := json.Marshal(game.StatusFields)
+	gameDb := &Game{
+		Title:               game.Title,
+		TitleImage:          game.TitleImage,
+		Description:         game.Description,
+		Scenario:            game.Scenario,
+		SessionStartSyscall: game.SessionStartSyscall,
+		ImageStyle:          game.ImageStyle,
+		StatusFields:        string(statusFieldsSerialized),
+		Tags:                joinTags(game.Tags),
+		Temperature:         game.Temperature,
+		TopP:                game.TopP,
+		MaxOutputTokens:     game.MaxOutputTokens,
+		MinTurns:            game.MinTurns,
+		MaxTurns:            game.MaxTurns,
+		SharePlayHash:       randomHash(),
+	}
+	if err := db.Model(&user).Association("Games").Append(gameDb); err != nil {
+		return err
+	}
+	game.ID = gameDb.ID
+	return nil
+}
+
 func (user *User) UpdateGame(updatedGame obj.Game) error {
 	game, err := user.getGame(updatedGame.ID)
 	if err != nil {
@@ -134,8 +284,16 @@ func (user *User) UpdateGame(updatedGame obj.Game) error {
 	game.SessionStartSyscall = updatedGame.SessionStartSyscall
 	game.StatusFields = string(statusFieldsSerialized)
 	game.ImageStyle = updatedGame.ImageStyle
+	game.Tags = joinTags(updatedGame.Tags)
+	game.Temperature = updatedGame.Temperature
+	game.TopP = updatedGame.TopP
+	game.MaxOutputTokens = updatedGame.MaxOutputTokens
+	game.MinTurns = updatedGame.MinTurns
+	game.MaxTurns = updatedGame.MaxTurns
 	game.SharePlayActive = updatedGame.SharePlayActive
 	game.ShareEditActive = updatedGame.ShareEditActive
+	game.SponsorNotice = updatedGame.SponsorNotice
+	game.SponsorConsentRequired = updatedGame.SponsorConsentRequired
 
 	if game.SharePlayHash == "" {
 		game.SharePlayHash = randomHash()
@@ -144,12 +302,41 @@ func (user *User) UpdateGame(updatedGame obj.Game) error {
 	return game.update()
 }
 
+// GetGameTitleImage returns the cover image of a game, or nil if it has none
+func (user *User) GetGameTitleImage(gameId uint) ([]byte, *obj.HTTPError) {
+	game, err := user.getGame(gameId)
+	if err != nil {
+		return nil, err
+	}
+	return game.TitleImage, nil
+}
+
+// SetGameTitleImage replaces the cover image of a game
+func (user *User) SetGameTitleImage(gameId uint, image []byte) *obj.HTTPError {
+	game, httpErr := user.getGame(gameId)
+	if httpErr != nil {
+		return httpErr
+	}
+	if err := db.Model(game).Update("title_image", image).Error; err != nil {
+		return obj.ErrorToHTTPError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
 func (user *User) Export() *obj.User {
 	return &obj.User{
 		ID:                user.ID,
 		Name:              user.Name,
 		OpenAiKeyPersonal: shortenOpenaiKey(user.OpenAiKeyPersonal),
-		OpenAiKeyPublish:  shortenOpenaiKey(user.OpenAiKeyPublish),
+		OpenAiKeyPersonalStatus: obj.ApiKeyStatus{
+			Valid:       user.OpenAiKeyPersonalValid,
+			ValidatedAt: user.OpenAiKeyPersonalValidatedAt,
+		},
+		OpenAiKeyPublish: shortenOpenaiKey(user.OpenAiKeyPublish),
+		OpenAiKeyPublishStatus: obj.ApiKeyStatus{
+			Valid:       user.OpenAiKeyPublishValid,
+			ValidatedAt: user.OpenAiKeyPublishValidatedAt,
+		},
 	}
 }
 
@@ -166,13 +353,46 @@ func (user *User) Update(name string, email string) {
 	db.Save(user)
 }
 
+// UpdateApiKeyPublish sets the key for public games - it is unvalidated until SetApiKeyStatus is called
 func (user *User) UpdateApiKeyPublish(publish string) {
 	user.OpenAiKeyPublish = publish
+	user.OpenAiKeyPublishValid = false
+	user.OpenAiKeyPublishValidatedAt = nil
 	db.Save(user)
 }
 
+// UpdateApiKeyPersonal sets the key for the user's own play - it is unvalidated until SetApiKeyStatus is called
 func (user *User) UpdateApiKeyPersonal(personal string) {
 	user.OpenAiKeyPersonal = personal
+	user.OpenAiKeyPersonalValid = false
+	user.OpenAiKeyPersonalValidatedAt = nil
+	db.Save(user)
+}
+
+// ApiKey returns the user's key of the given type (obj.ApiKeyPersonal or obj.ApiKeyPublish)
+func (user *User) ApiKey(keyType string) string {
+	switch keyType {
+	case obj.ApiKeyPersonal:
+		return user.OpenAiKeyPersonal
+	case obj.ApiKeyPublish:
+		return user.OpenAiKeyPublish
+	}
+	return ""
+}
+
+// SetApiKeyStatus stores the result of checking the user's key of the given type against the provider
+func (user *User) SetApiKeyStatus(keyType string, valid bool) {
+	now := time.Now()
+	switch keyType {
+	case obj.ApiKeyPersonal:
+		user.OpenAiKeyPersonalValid = valid
+		user.OpenAiKeyPersonalValidatedAt = &now
+	case obj.ApiKeyPublish:
+		user.OpenAiKeyPublishValid = valid
+		user.OpenAiKeyPublishValidatedAt = &now
+	default:
+		return
+	}
 	db.Save(user)
 }
 
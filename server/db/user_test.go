@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+	"webapp-server/obj"
+)
+
+func TestDeleteAndRestoreGame(t *testing.T) {
+	initTestDb(t)
+	owner := &User{Auth0ID: "owner", Name: "owner"}
+	other := &User{Auth0ID: "other", Name: "other"}
+	assert.NoError(t, CreateUser(owner))
+	assert.NoError(t, CreateUser(other))
+	game := obj.Game{Title: "The Castle"}
+	assert.NoError(t, owner.CreateGame(&game))
+
+	// deleted games can't be played anymore
+	assert.Nil(t, owner.DeleteGame(game.ID))
+	_, err := GetGameByID(game.ID)
+	assert.Error(t, err)
+	games, _ := owner.ListGames(obj.GameListOptions{Sort: obj.GameSortCreatedAt})
+	assert.Empty(t, games.Games)
+
+	// only the owner sees and restores them
+	deleted, httpErr := owner.GetGameIncludingDeleted(game.ID)
+	assert.Nil(t, httpErr)
+	assert.NotNil(t, deleted.DeletedAt)
+	_, httpErr = other.GetGameIncludingDeleted(game.ID)
+	assert.NotNil(t, httpErr)
+	assert.NotNil(t, other.RestoreGame(game.ID))
+
+	assert.Nil(t, owner.RestoreGame(game.ID))
+	restored, err := GetGameByID(game.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.NotNil(t, owner.RestoreGame(game.ID))
+
+	// after the restore window the deletion is final
+	assert.Nil(t, owner.DeleteGame(game.ID))
+	db.Unscoped().Model(&Game{}).Where("id = ?", game.ID).Update("deleted_at", time.Now().Add(-gameRestoreWindow-time.Hour))
+	httpErr = owner.RestoreGame(game.ID)
+	assert.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusGone, httpErr.StatusCode)
+}
+
+func TestGetRecentGames(t *testing.T) {
+	initTestDb(t)
+	player := &User{Auth0ID: "player"}
+	author := &User{Auth0ID: "author"}
+	assert.NoError(t, CreateUser(player))
+	assert.NoError(t, CreateUser(author))
+	own := obj.Game{Title: "own"}
+	shared := obj.Game{Title: "shared"}
+	unshared := obj.Game{Title: "unshared"}
+	assert.NoError(t, player.CreateGame(&own))
+	assert.NoError(t, author.CreateGame(&shared))
+	assert.NoError(t, author.CreateGame(&unshared))
+	shared.SharePlayActive = true
+	assert.NoError(t, author.UpdateGame(shared))
+
+	ownSession, _ := CreateSession(&obj.Session{GameID: own.ID, UserID: player.ID})
+	_, _ = CreateSession(&obj.Session{GameID: shared.ID, UserID: player.ID})
+	_, _ = CreateSession(&obj.Session{GameID: unshared.ID, UserID: player.ID})
+	time.Sleep(10 * time.Millisecond)
+	_, _ = AddChapter(ownSession.ID, 1, "input", "output", "image")
+
+	games, httpErr := player.GetRecentGames(10)
+	assert.Nil(t, httpErr)
+	if assert.Len(t, games, 2) {
+		assert.Equal(t, own.ID, games[0].ID)
+		assert.Equal(t, shared.ID, games[1].ID)
+	}
+
+	games, _ = player.GetRecentGames(1)
+	assert.Len(t, games, 1)
+}
+
+func TestImportGame(t *testing.T) {
+	initTestDb(t)
+	user := &User{Auth0ID: "importer"}
+	assert.NoError(t, CreateUser(user))
+
+	game := &obj.Game{Title: "Imported", Scenario: "A ship at sea", StatusFields: []obj.StatusField{{Name: "Crew", Value: "12"}}, TitleImage: []byte{1, 2, 3}}
+	assert.NoError(t, user.ImportGame(game))
+
+	imported, httpErr := user.GetGame(context.Background(), game.ID)
+	assert.Nil(t, httpErr)
+	assert.Equal(t, "A ship at sea", imported.Scenario)
+	assert.Equal(t, game.StatusFields, imported.StatusFields)
+	assert.True(t, imported.HasTitleImage)
+	assert.NotEmpty(t, imported.SharePlayHash)
+	assert.False(t, imported.SharePlayActive)
+}
+
+func TestListGames(t *testing.T) {
+	initTestDb(t)
+	user := &User{Auth0ID: "user"}
+	author := &User{Auth0ID: "author"}
+	assert.NoError(t, CreateUser(user))
+	assert.NoError(t, CreateUser(author))
+	own := obj.Game{Title: "Dungeon", Description: "100% dark", Tags: []string{"Horror", " rpg", "horror"}}
+	shared := obj.Game{Title: "Castle", SharePlayActive: true, Tags: []string{"rpg_light"}}
+	unshared := obj.Game{Title: "Dungeon of the author"}
+	assert.NoError(t, user.CreateGame(&own))
+	assert.NoError(t, user.UpdateGame(own))
+	assert.NoError(t, author.CreateGame(&shared))
+	assert.NoError(t, author.UpdateGame(shared))
+	assert.NoError(t, author.CreateGame(&unshared))
+
+	titles := func(options obj.GameListOptions) []string {
+		games, httpErr := user.ListGames(options)
+		assert.Nil(t, httpErr)
+		result := make([]string, len(games.Games))
+		for i, game := range games.Games {
+			result[i] = game.Title
+		}
+		return result
+	}
+	yes, no := true, false
+
+	assert.Equal(t, []string{"Dungeon", "Castle"}, titles(obj.GameListOptions{Sort: obj.GameSortCreatedAt}))
+	assert.Equal(t, []string{"Castle", "Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle}))
+	assert.Equal(t, []string{"Dungeon", "Castle"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Descending: true}))
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Mine: &yes}))
+	assert.Equal(t, []string{"Castle"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Public: &yes}))
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Public: &no}))
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Search: "dun"}))
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Search: "0%"}))
+	assert.Empty(t, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Search: "x%"}))
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Limit: 1, Offset: 1}))
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Offset: 1}))
+
+	assert.Equal(t, []string{"Dungeon"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Tag: "RPG"}))
+	assert.Equal(t, []string{"Castle"}, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Tag: "rpg_light"}))
+	assert.Empty(t, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Tag: "rp"}))
+	assert.Empty(t, titles(obj.GameListOptions{Sort: obj.GameSortTitle, Tag: "rpg_"}))
+
+	games, httpErr := user.ListGames(obj.GameListOptions{Sort: obj.GameSortTitle, Limit: 1})
+	assert.Nil(t, httpErr)
+	assert.Len(t, games.Games, 1)
+	assert.Equal(t, int64(2), games.Total)
+	stored, _ := user.GetGame(context.Background(), own.ID)
+	assert.Equal(t, []string{"horror", "rpg"}, stored.Tags)
+
+	_, httpErr = user.ListGames(obj.GameListOptions{Sort: "unknown"})
+	assert.NotNil(t, httpErr)
+}
+
+func TestIncrementPlayCount(t *testing.T) {
+	initTestDb(t)
+	user := &User{Auth0ID: "user"}
+	assert.NoError(t, CreateUser(user))
+	game := obj.Game{Title: "Popular"}
+	assert.NoError(t, user.CreateGame(&game))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, IncrementPlayCount(game.ID))
+		}()
+	}
+	wg.Wait()
+
+	// editing the game must not reset the count
+	game.PlayCount = 0
+	assert.NoError(t, user.UpdateGame(game))
+	played, err := GetGameByID(game.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, played.PlayCount)
+}
+
+func TestGetTitleImageByPublicHash(t *testing.T) {
+	initTestDb(t)
+	user := &User{Auth0ID: "author"}
+	assert.NoError(t, CreateUser(user))
+	game := obj.Game{Title: "Covered"}
+	assert.NoError(t, user.CreateGame(&game))
+	assert.Nil(t, user.SetGameTitleImage(game.ID, []byte{1, 2, 3}))
+	created, _ := user.GetGame(context.Background(), game.ID)
+
+	// only games shared for play show their cover to everyone
+	_, httpErr := GetTitleImageByPublicHash(created.SharePlayHash)
+	assert.NotNil(t, httpErr)
+
+	created.SharePlayActive = true
+	assert.NoError(t, user.UpdateGame(*created))
+	image, httpErr := GetTitleImageByPublicHash(created.SharePlayHash)
+	assert.Nil(t, httpErr)
+	assert.Equal(t, []byte{1, 2, 3}, image)
+}
@@ -9,7 +9,18 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/sashabaranov/go-openai v1.26.0
 	github.com/stretchr/testify v1.9.0
-	golang.org/x/crypto v0.21.0 // indirect
+	gopkg.in/go-jose/go-jose.v2 v2.6.1
 	gorm.io/driver/sqlite v1.5.4
 	gorm.io/gorm v1.25.5
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.17 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/crypto v0.21.0 // indirect
+	golang.org/x/sync v0.5.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
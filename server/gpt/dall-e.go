@@ -3,9 +3,9 @@ package gpt
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
-	"log"
 	"net/http"
 	"time"
 	"webapp-server/obj"
@@ -26,14 +26,55 @@ func GenerateImage(ctx context.Context, apiKey string, prompt string) (image []b
 	)
 	if err != nil {
 		return nil, &obj.HTTPError{
-			StatusCode: http.StatusInternalServerError,
-			Message:    fmt.Sprintf("Image creation error: %v\n", err),
+			StatusCode: imageErrorStatus(err),
+			Message:    fmt.Sprintf("Image creation error: %v", err),
 		}
 	}
-	log.Printf("Image created in %v\n", time.Since(timeStart))
+	obj.Logf(ctx, "Image created in %v\n", time.Since(timeStart))
 	data, err := base64.StdEncoding.DecodeString(respUrl.Data[0].B64JSON)
 	if err != nil {
 		return nil, &obj.HTTPError{StatusCode: http.StatusInternalServerError, Message: "Failed decoding generated image: " + err.Error()}
 	}
 	return data, nil
 }
+
+// imageErrorStatus tells the status of a failed image generation - the provider's, if it answered at all
+func imageErrorStatus(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// isTransientImageError tells whether generating the image again may succeed -
+// rejected prompts or invalid keys fail the same way on every attempt
+func isTransientImageError(httpErr *obj.HTTPError) bool {
+	return isOverloadStatus(httpErr.StatusCode)
+}
+
+// imageFailureReason describes a failed image generation to the game owner.
+// The provider's message isn't passed on, as it may quote parts of the player's api key.
+func imageFailureReason(httpErr *obj.HTTPError) string {
+	switch status := httpErr.StatusCode; {
+	case status == http.StatusBadRequest:
+		return "the image provider rejected the prompt, e.g. for its content policy (400)"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Sprintf("the image provider rejected the api key (%d)", status)
+	case status == http.StatusTooManyRequests:
+		return "the rate limit of the image provider was exceeded (429)"
+	case status == http.StatusGatewayTimeout:
+		return "the image provider didn't answer in time"
+	case status >= http.StatusInternalServerError:
+		return fmt.Sprintf("the image provider failed (%d)", status)
+	default:
+		return fmt.Sprintf("the image provider answered with status %d", status)
+	}
+}
@@ -2,9 +2,12 @@ package gpt
 
 import (
 	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"webapp-server/obj"
 )
 
 func apiKey() string {
@@ -23,3 +26,22 @@ func TestDalleImageGeneration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, image)
 }
+
+func TestIsTransientImageError(t *testing.T) {
+	transient := func(err error) bool {
+		return isTransientImageError(&obj.HTTPError{StatusCode: imageErrorStatus(err)})
+	}
+	assert.True(t, transient(&openai.APIError{HTTPStatusCode: 429}))
+	assert.True(t, transient(&openai.RequestError{HTTPStatusCode: 503}))
+	assert.True(t, transient(fmt.Errorf("post: %w", context.DeadlineExceeded)))
+	assert.False(t, transient(&openai.APIError{HTTPStatusCode: 400, Code: "content_policy_violation"}))
+	assert.False(t, transient(&openai.APIError{HTTPStatusCode: 401}))
+}
+
+func TestImageFailureReason(t *testing.T) {
+	apiErr := &openai.APIError{HTTPStatusCode: 401, Message: "Incorrect API key provided: sk-cLoId***88Qy"}
+	reason := imageFailureReason(&obj.HTTPError{StatusCode: imageErrorStatus(apiErr), Message: apiErr.Error()})
+	assert.Equal(t, "the image provider rejected the api key (401)", reason)
+	assert.NotContains(t, reason, "sk-")
+	assert.Equal(t, "the image provider didn't answer in time", imageFailureReason(&obj.HTTPError{StatusCode: 504}))
+}
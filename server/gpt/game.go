@@ -3,11 +3,12 @@ package gpt
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
-	"log"
 	"net/http"
 	"strings"
+	"time"
 	"webapp-server/constants"
 	"webapp-server/db"
 	"webapp-server/obj"
@@ -40,17 +41,33 @@ Any changes to the JSON structure will break the game frontend.
 
 You always stay in your role. You are the game master. You are the world. You are the narrator. You are the storyteller. You decide, what's possible and what not. You are the text-adventure engine. You are the game. Don't please the player, challenge him.
 
-The scenario:
+{{TURNS}}The scenario:
 
 {{SCENARIO}}
 `
 
-func CreateGameSession(game *obj.Game, userId uint, apiKey string) (session *obj.Session, err error) {
+// turnInstructions tells the assistant about the turn limits of the game, if it has any
+func turnInstructions(game *obj.Game) string {
+	var sb strings.Builder
+	if game.MinTurns > 0 {
+		sb.WriteString(fmt.Sprintf("The story must not end before the player took %d actions - don't let the player win or die earlier.\n", game.MinTurns))
+	}
+	if game.MaxTurns > 0 {
+		sb.WriteString(fmt.Sprintf("The game ends after %d player actions. Each player action carries its number in the field \"turn\". ", game.MaxTurns))
+		sb.WriteString("If the field \"lastTurn\" is true, bring the story to a conclusive end with this answer.\n")
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func CreateGameSession(ctx context.Context, game *obj.Game, userId uint, apiKey string) (session *obj.Session, err error) {
 	if game == nil {
 		return nil, fmt.Errorf("game is nil")
 	}
 
-	log.Printf("CreateGameSession, game.ID %d, userId %d", game.ID, userId)
+	obj.Logf(ctx, "CreateGameSession, game.ID %d, userId %d", game.ID, userId)
 
 	actionInput := obj.GameActionInput{
 		Type:    obj.GameInputTypeAction,
@@ -75,13 +92,14 @@ func CreateGameSession(game *obj.Game, userId uint, apiKey string) (session *obj
 	instructions := template
 	instructions = strings.ReplaceAll(instructions, "{{INPUT_EXAMPLE}}", string(actionInputStr))
 	instructions = strings.ReplaceAll(instructions, "{{OUTPUT_EXAMPLE}}", string(actionOutputStr))
+	instructions = strings.ReplaceAll(instructions, "{{TURNS}}", turnInstructions(game))
 	instructions = strings.ReplaceAll(instructions, "{{SCENARIO}}", game.Scenario)
-	log.Printf("Instructions: %s", instructions)
+	obj.Logf(ctx, "Instructions: %s", instructions)
 
 	assistantName := fmt.Sprintf("%s #%d", constants.ProjectName, game.ID)
-	assistantId, threadId, err := initAssistant(context.Background(), assistantName, instructions, apiKey)
+	assistantId, threadId, err := initAssistant(ctx, assistantName, instructions, apiKey)
 	if err != nil {
-		log.Printf("initAssistant failed: %s", err.Error())
+		obj.Logf(ctx, "initAssistant failed: %s", err.Error())
 		return nil, err
 	}
 	return &obj.Session{
@@ -93,26 +111,45 @@ func CreateGameSession(game *obj.Game, userId uint, apiKey string) (session *obj
 	}, nil
 }
 
-func ExecuteAction(session *obj.Session, game *obj.Game, action obj.GameActionInput, apiKey string) (response *obj.GameActionOutput, httpErr *obj.HTTPError) {
+const (
+	imageAttempts       = 2
+	imageAttemptTimeout = 20 * time.Second
+	imageRetryDelay     = 2 * time.Second
+)
+
+// actionTimeout is how long the assistant may take to answer a player action
+const actionTimeout = 2 * time.Minute
+
+// ImageGenerationTimeout is the longest it takes, until a chapter has its image or is marked text-only
+const ImageGenerationTimeout = imageAttempts*imageAttemptTimeout + (imageAttempts-1)*imageRetryDelay
+
+func ExecuteAction(ctx context.Context, session *obj.Session, game *obj.Game, action obj.GameActionInput, apiKey string) (response *obj.GameActionOutput, httpErr *obj.HTTPError) {
 	var err error
 	actionSerialized, _ := json.Marshal(action)
-	log.Printf("ExecuteAction, session %d, action %s", session.ID, string(actionSerialized))
+	obj.Logf(ctx, "ExecuteAction, session %d, action %s", session.ID, string(actionSerialized))
 
+	// the session is locked while its action runs - a hanging run mustn't block it forever
+	actionCtx, cancel := context.WithTimeout(ctx, actionTimeout)
+	defer cancel()
 	var gptResponse string
 	if gptResponse, err = AddMessageToThread(
-		context.Background(),
+		actionCtx,
 		*session,
+		game,
 		openai.ChatMessageRoleUser,
 		string(actionSerialized),
 		apiKey,
 	); err != nil {
-		log.Printf("AddMessageToThread failed: %s", err.Error())
+		obj.Logf(ctx, "AddMessageToThread failed: %s", err.Error())
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, &obj.HTTPError{StatusCode: http.StatusGatewayTimeout, Message: "GPT error: no answer within " + actionTimeout.String()}
+		}
 		return nil, &obj.HTTPError{StatusCode: 500, Message: "GPT error: " + err.Error()}
 	}
 	gptResponse = strings.TrimPrefix(gptResponse, "```json")
 	gptResponse = strings.TrimSuffix(gptResponse, "```")
 	gptResponse = strings.TrimSpace(gptResponse)
-	log.Printf("GPT responded: %s", gptResponse)
+	obj.Logf(ctx, "GPT responded: %s", gptResponse)
 
 	if err = json.Unmarshal([]byte(gptResponse), &response); err != nil {
 		response = &obj.GameActionOutput{
@@ -139,15 +176,33 @@ func ExecuteAction(session *obj.Session, game *obj.Game, action obj.GameActionIn
 	go func() {
 		var image []byte
 		var imageErr *obj.HTTPError
-		if image, imageErr = GenerateImage(context.Background(), apiKey, response.Image); imageErr != nil {
-			log.Printf("failed generating image: %s", imageErr)
+		// the image provider hiccups now and then - retry once on timeouts and overload, then let the chapter go on without image
+		for attempt := 1; attempt <= imageAttempts; attempt++ {
+			attemptCtx, cancel := context.WithTimeout(ctx, imageAttemptTimeout)
+			image, imageErr = GenerateImage(attemptCtx, apiKey, response.Image)
+			cancel()
+			if imageErr == nil {
+				break
+			}
+			obj.Logf(ctx, "failed generating image for session %d chapter %d, attempt %d: %s", session.ID, action.ChapterId, attempt, imageErr)
+			if !isTransientImageError(imageErr) {
+				break
+			}
+			if attempt < imageAttempts {
+				time.Sleep(imageRetryDelay)
+			}
+		}
+		if imageErr != nil {
+			if imageErr = db.SetImageFailed(session.ID, action.ChapterId, imageFailureReason(imageErr)); imageErr != nil {
+				obj.Logf(ctx, "failed marking image of chapter as failed: %s", imageErr)
+			}
 			return
 		}
 		if imageErr = db.SetImage(session.ID, action.ChapterId, image); imageErr != nil {
-			log.Printf("failed saving image to chapter: %s", imageErr)
+			obj.Logf(ctx, "failed saving image to chapter: %s", imageErr)
 			return
 		}
-		log.Printf("sucessfully generated and stored image for session %d chapter %d", session.ID, action.ChapterId)
+		obj.Logf(ctx, "sucessfully generated and stored image for session %d chapter %d", session.ID, action.ChapterId)
 	}()
 
 	return response, nil
@@ -2,9 +2,11 @@ package gpt
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
-	"log"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 	"webapp-server/obj"
@@ -14,21 +16,17 @@ func newClient(apiKey string) *openai.Client {
 	return openai.NewClient(apiKey)
 }
 
-func initAssistant(ctx context.Context, name, instructions, apiKey string) (assistantId string, threadId string, err error) {
-	log.Printf("initAssistant: %s", name)
-
-	log.Printf("newClient..")
-	client := newClient(apiKey)
-
-	models, err := client.ListModels(context.Background())
+// selectModel picks the newest GPT-4 class model the api key has access to
+func selectModel(ctx context.Context, client *openai.Client, apiKey string) (string, error) {
+	models, err := client.ListModels(ctx)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 	bestModel := ""
 	var bestModelVersion float64
 	var bestModelDate int64
 	for _, model := range models.Models {
-		log.Printf("Model: %s", model.ID)
+		obj.Logf(ctx, "Model: %s", model.ID)
 		var modelVersion float64
 		var modelDate int64
 		if strings.HasPrefix(model.ID, "gpt-3.5-turbo") {
@@ -49,13 +47,40 @@ func initAssistant(ctx context.Context, name, instructions, apiKey string) (assi
 			bestModelDate = modelDate
 		}
 	}
-	log.Printf("Best model for api key %s: %s", apiKey, bestModel)
+	obj.Logf(ctx, "Best model for api key %s: %s", redactApiKey(apiKey), bestModel)
 	if bestModelVersion < 4 {
 		if len(apiKey) < 5 {
-			log.Printf("Malformed API key: %s", apiKey)
-			return "", "", fmt.Errorf("malformed API key")
+			obj.Logf(ctx, "Malformed API key of length %d", len(apiKey))
+			return "", fmt.Errorf("malformed API key")
 		}
-		return "", "", fmt.Errorf("API key %s does not have access to GPT-4", apiKey[:5]+"..."+apiKey[len(apiKey)-5:])
+		return "", fmt.Errorf("API key %s does not have access to GPT-4", redactApiKey(apiKey))
+	}
+	return bestModel, nil
+}
+
+// redactApiKey shortens an api key to its first and last characters, so it can be logged
+func redactApiKey(apiKey string) string {
+	if len(apiKey) < 10 {
+		return "..."
+	}
+	return apiKey[:5] + "..." + apiKey[len(apiKey)-5:]
+}
+
+// ValidateApiKey checks whether the api key works and has access to a model suitable for running games
+func ValidateApiKey(ctx context.Context, apiKey string) error {
+	_, err := selectModel(ctx, newClient(apiKey), apiKey)
+	return err
+}
+
+func initAssistant(ctx context.Context, name, instructions, apiKey string) (assistantId string, threadId string, err error) {
+	obj.Logf(ctx, "initAssistant: %s", name)
+
+	obj.Logf(ctx, "newClient..")
+	client := newClient(apiKey)
+
+	bestModel, err := selectModel(ctx, client, apiKey)
+	if err != nil {
+		return "", "", err
 	}
 
 	assistantCfg := openai.AssistantRequest{
@@ -85,9 +110,9 @@ func initAssistant(ctx context.Context, name, instructions, apiKey string) (assi
 	var assistant openai.Assistant
 	//if assistantId == "" {
 	//log.Printf("Assistant '%s' not found, creating\n", name)
-	assistant, err = client.CreateAssistant(context.Background(), assistantCfg)
+	assistant, err = client.CreateAssistant(ctx, assistantCfg)
 	assistantId = assistant.ID
-	log.Printf("Assistant '%s' created, id=%s\n", name, assistant.ID)
+	obj.Logf(ctx, "Assistant '%s' created, id=%s\n", name, assistant.ID)
 	//} else {
 	//	assistant, err = client.ModifyAssistant(context.Background(), assistantId, assistantCfg)
 	//	log.Printf("Assistant '%s' updated, id=%s\n", name, assistant.ID)
@@ -102,13 +127,110 @@ func initAssistant(ctx context.Context, name, instructions, apiKey string) (assi
 	}); err != nil {
 		return
 	}
-	log.Printf("Thread created: %s\n", thread.ID)
+	obj.Logf(ctx, "Thread created: %s\n", thread.ID)
 	threadId = thread.ID
 
 	return
 }
 
-func AddMessageToThread(ctx context.Context, session obj.Session, role, message, apiKey string) (response string, err error) {
+// fallbackModels returns the models to retry a run with, if the assistant's model is overloaded.
+// They are configured as comma separated list in OPENAI_FALLBACK_MODELS.
+func fallbackModels() []string {
+	var models []string
+	for _, model := range strings.Split(os.Getenv("OPENAI_FALLBACK_MODELS"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// runFailedError is returned, if the provider accepted a run, but failed processing it
+type runFailedError struct {
+	code    openai.RunError
+	message string
+}
+
+func (e runFailedError) Error() string {
+	return fmt.Sprintf("run failed: %s (%s)", e.message, e.code)
+}
+
+// isProviderOverloaded tells whether an error is worth retrying with another model
+func isProviderOverloaded(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return isOverloadStatus(apiErr.HTTPStatusCode)
+	}
+	// error pages of proxies and gateways aren't json, the client reports them as request errors
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return isOverloadStatus(requestErr.HTTPStatusCode)
+	}
+	var runErr runFailedError
+	if errors.As(err, &runErr) {
+		return runErr.code == openai.RunErrorRateLimitExceeded || runErr.code == openai.RunErrorServerError
+	}
+	return false
+}
+
+func isOverloadStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// runCancelTimeout is how long cancelling a run, which exceeded its deadline, may take
+const runCancelTimeout = 10 * time.Second
+
+// runThread runs the assistant on the thread and waits until the run is finished.
+// If ctx expires before, the run is cancelled, so that the thread accepts new runs again.
+func runThread(ctx context.Context, client *openai.Client, threadId string, runRequest openai.RunRequest) (run openai.Run, err error) {
+	if run, err = client.CreateRun(ctx, threadId, runRequest); err != nil {
+		return
+	}
+	obj.Logf(ctx, "Run %s created", run.ID)
+	defer func() {
+		if ctx.Err() == nil || (run.Status != openai.RunStatusQueued && run.Status != openai.RunStatusInProgress) {
+			return
+		}
+		cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runCancelTimeout)
+		defer cancel()
+		if _, cancelErr := client.CancelRun(cancelCtx, threadId, run.ID); cancelErr != nil {
+			obj.Logf(ctx, "Failed cancelling run %s: %s", run.ID, cancelErr.Error())
+		}
+	}()
+
+	for run.Status == openai.RunStatusQueued || run.Status == openai.RunStatusInProgress {
+		var current openai.Run
+		if current, err = client.RetrieveRun(ctx, threadId, run.ID); err != nil {
+			if !isProviderOverloaded(err) {
+				return
+			}
+			// the run goes on at the provider - it must end before another run may be started on the thread
+			obj.Logf(ctx, "Polling run %s failed, retrying: %s", run.ID, err.Error())
+			err = nil
+		} else {
+			run = current
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+	obj.Logf(ctx, "Run %s finished with status %s", run.ID, run.Status)
+
+	if run.Status == openai.RunStatusFailed {
+		err = runFailedError{message: "unknown error"}
+		if run.LastError != nil {
+			err = runFailedError{code: run.LastError.Code, message: run.LastError.Message}
+		}
+	}
+	return
+}
+
+// AddMessageToThread posts a message to the session's thread and waits for the assistant's answer.
+// The game is optional - if given, its sampling settings are applied to the run.
+func AddMessageToThread(ctx context.Context, session obj.Session, game *obj.Game, role, message, apiKey string) (response string, err error) {
 	client := newClient(apiKey)
 
 	var messageObject openai.Message
@@ -118,23 +240,39 @@ func AddMessageToThread(ctx context.Context, session obj.Session, role, message,
 	}); err != nil {
 		return
 	}
-	log.Printf("Message created: %s\n", messageObject.ID)
+	obj.Logf(ctx, "Message created: %s\n", messageObject.ID)
 
-	var run openai.Run
-	if run, err = client.CreateRun(ctx, session.ThreadID, openai.RunRequest{
+	runRequest := openai.RunRequest{
 		AssistantID: session.AssistantID,
-	}); err != nil {
-		return
 	}
-	log.Printf("Run %s created", run.ID)
+	if game != nil {
+		runRequest.Temperature = game.Temperature
+		runRequest.TopP = game.TopP
+		runRequest.MaxCompletionTokens = game.MaxOutputTokens
+	}
 
-	for run.Status == openai.RunStatusQueued || run.Status == openai.RunStatusInProgress {
-		if run, err = client.RetrieveRun(ctx, session.ThreadID, run.ID); err != nil {
-			return
+	// the assistant's own model is tried first, then the configured fallbacks
+	var run openai.Run
+	models := append([]string{""}, fallbackModels()...)
+	for i, model := range models {
+		runRequest.Model = model
+		if run, err = runThread(ctx, client, session.ThreadID, runRequest); err == nil || !isProviderOverloaded(err) || i == len(models)-1 {
+			break
 		}
-		time.Sleep(1 * time.Second)
+		obj.Logf(ctx, "Run failed with model '%s', falling back to '%s': %s", model, models[i+1], err.Error())
+	}
+	if err != nil {
+		return
+	}
+	if run.Status == openai.RunStatusIncomplete {
+		err = fmt.Errorf("run %s is incomplete - the story exceeded the output token limit of the game", run.ID)
+		return
+	}
+	// without a completed run, the newest message of the thread is still the player's input
+	if run.Status != openai.RunStatusCompleted {
+		err = fmt.Errorf("run %s ended with status %s", run.ID, run.Status)
+		return
 	}
-	log.Printf("Run %s completed", run.ID)
 
 	limit := 1
 	var msgList openai.MessagesList
@@ -145,7 +283,7 @@ func AddMessageToThread(ctx context.Context, session obj.Session, role, message,
 		err = fmt.Errorf("expected 1 message, got %d", len(msgList.Messages))
 		return
 	}
-	log.Println("Fetched messages")
+	obj.Logf(ctx, "Fetched messages")
 
 	content := msgList.Messages[0].Content
 	if len(content) != 1 {
@@ -2,10 +2,15 @@ package gpt
 
 import (
 	"context"
+	"fmt"
 	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 	"webapp-server/constants"
 	"webapp-server/obj"
 )
@@ -20,8 +25,56 @@ func TestCreateThread(t *testing.T) {
 	assert.NotEmpty(t, threadId)
 
 	var response string
-	response, err = AddMessageToThread(ctx, obj.Session{ThreadID: threadId, AssistantID: assistantId}, openai.ChatMessageRoleUser, "I look around the room", apiKey())
+	response, err = AddMessageToThread(ctx, obj.Session{ThreadID: threadId, AssistantID: assistantId}, nil, openai.ChatMessageRoleUser, "I look around the room", apiKey())
 	assert.NoError(t, err)
 	assert.NotEmpty(t, response)
 	log.Printf("Message response: %s\n", response)
 }
+
+func TestIsProviderOverloaded(t *testing.T) {
+	assert.True(t, isProviderOverloaded(&openai.APIError{HTTPStatusCode: 429}))
+	assert.True(t, isProviderOverloaded(&openai.APIError{HTTPStatusCode: 503}))
+	assert.False(t, isProviderOverloaded(&openai.APIError{HTTPStatusCode: 401}))
+	assert.True(t, isProviderOverloaded(fmt.Errorf("create run: %w", &openai.RequestError{HTTPStatusCode: 502})))
+	assert.True(t, isProviderOverloaded(&openai.RequestError{HTTPStatusCode: 429}))
+	assert.False(t, isProviderOverloaded(&openai.RequestError{HTTPStatusCode: 404}))
+	assert.True(t, isProviderOverloaded(runFailedError{code: openai.RunErrorServerError}))
+	assert.False(t, isProviderOverloaded(runFailedError{code: "invalid_prompt"}))
+	assert.False(t, isProviderOverloaded(fmt.Errorf("some other error")))
+}
+
+func TestFallbackModels(t *testing.T) {
+	t.Setenv("OPENAI_FALLBACK_MODELS", " gpt-4o-mini, ,gpt-4-turbo")
+	assert.Equal(t, []string{"gpt-4o-mini", "gpt-4-turbo"}, fallbackModels())
+	t.Setenv("OPENAI_FALLBACK_MODELS", "")
+	assert.Empty(t, fallbackModels())
+}
+
+func TestRedactApiKey(t *testing.T) {
+	assert.Equal(t, "sk-cL...U88Qy", redactApiKey("sk-cLoIdjNmT0qkZizSrzZ222BlbkFJKFSqlHsO9AsRO6LU88Qy"))
+	assert.Equal(t, "...", redactApiKey("sk-short"))
+}
+
+func TestRunThreadCancelsRunAfterDeadline(t *testing.T) {
+	var cancelled atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/threads/thread_1/runs/run_1/cancel" {
+			cancelled.Store(true)
+			_, _ = w.Write([]byte(`{"id":"run_1","status":"cancelling"}`))
+			return
+		}
+		// the run never leaves the queue
+		_, _ = w.Write([]byte(`{"id":"run_1","status":"queued"}`))
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("sk-test")
+	config.BaseURL = server.URL + "/v1"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := runThread(ctx, openai.NewClientWithConfig(config), "thread_1", openai.RunRequest{AssistantID: "assistant_1"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, isProviderOverloaded(err))
+	assert.True(t, cancelled.Load())
+}
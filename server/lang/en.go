@@ -5,4 +5,5 @@ var (
 	ErrorNoValidKey             = "No valid key found for running this game"
 	ErrorParsingRequest         = "Failed parsing request to server"
 	ErrorFailedUpdatingGameData = "Failed updating game data"
+	ErrorImageGenerationFailed  = "The image for this chapter could not be generated"
 )
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"webapp-server/api"
 	"webapp-server/db"
+	"webapp-server/obj"
 
 	"github.com/joho/godotenv"
 	"webapp-server/router"
@@ -19,7 +20,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, "+obj.RequestIDHeader)
+		w.Header().Set("Access-Control-Expose-Headers", obj.RequestIDHeader)
 
 		// If this is a preflight request, the method will be OPTIONS,
 		// so no further processing is needed
@@ -40,13 +42,19 @@ func main() {
 	db.Init()
 
 	theRouter := router.NewRouter([]router.Endpoint{
+		api.AuthCheck,
 		api.Game,
 		api.Games,
+		api.GamesRecent,
+		api.HealthLive,
+		api.HealthReady,
 		api.Image,
 		api.Session,
+		api.Sessions,
 		api.Status,
 		api.Upgrade,
 		api.User,
+		api.UserKeys,
 		api.PublicGame,
 		api.PublicSession,
 	})
@@ -4,11 +4,72 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 )
 
 type HTTPError struct {
 	StatusCode int
-	Message    string
+	// Code is a stable, machine-readable error code for clients - if empty, it is derived from the status code
+	Code    string
+	Message string
+	// RequestID lets users refer to the failed request in bug reports - set by the router
+	RequestID string
+}
+
+// Error codes, which clients can rely on instead of parsing the (english) message
+const (
+	ErrorCodeBadRequest                 = "BAD_REQUEST"
+	ErrorCodeUnauthorized               = "UNAUTHORIZED"
+	ErrorCodeForbidden                  = "FORBIDDEN"
+	ErrorCodeNotFound                   = "NOT_FOUND"
+	ErrorCodeMethodNotAllowed           = "METHOD_NOT_ALLOWED"
+	ErrorCodeConflict                   = "CONFLICT"
+	ErrorCodeGone                       = "GONE"
+	ErrorCodePayloadTooLarge            = "PAYLOAD_TOO_LARGE"
+	ErrorCodeInternal                   = "INTERNAL_ERROR"
+	ErrorCodeUnavailable                = "SERVICE_UNAVAILABLE"
+	ErrorCodeTimeout                    = "TIMEOUT"
+	ErrorCodeApiKeyUnavailable          = "API_KEY_UNAVAILABLE"
+	ErrorCodeInvalidGameSetting         = "INVALID_GAME_SETTING"
+	ErrorCodeSponsorConsentRequired     = "SPONSOR_CONSENT_REQUIRED"
+	ErrorCodeImageGenerationUnavailable = "IMAGE_GENERATION_UNAVAILABLE"
+	ErrorCodeImageGenerationFailed      = "IMAGE_GENERATION_FAILED"
+	ErrorCodeSessionEnded               = "SESSION_ENDED"
+	// the token codes tell clients whether a login is required, or just a token refresh.
+	// They are lowercase, as clients of the auth check match them like that.
+	ErrorCodeTokenMissing = "token_missing"
+	ErrorCodeTokenExpired = "token_expired"
+	ErrorCodeTokenInvalid = "token_invalid"
+)
+
+// ErrorCode returns the error's code, falling back to a generic code for its status
+func (e HTTPError) ErrorCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ErrorCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusGone:
+		return ErrorCodeGone
+	case http.StatusRequestEntityTooLarge:
+		return ErrorCodePayloadTooLarge
+	case http.StatusServiceUnavailable:
+		return ErrorCodeUnavailable
+	case http.StatusGatewayTimeout:
+		return ErrorCodeTimeout
+	}
+	return ErrorCodeInternal
 }
 
 func (e HTTPError) Error() string {
@@ -37,12 +98,18 @@ func ErrorToHTTPError(statusCode int, err error) *HTTPError {
 
 func (e HTTPError) Json() []byte {
 	type Error struct {
-		Type  string `json:"type"`
-		Error string `json:"error"`
+		Type      string `json:"type"`
+		Code      string `json:"code"`
+		Error     string `json:"error"`
+		Message   string `json:"message"` // the plain message, read by clients from before the error field
+		RequestID string `json:"requestId,omitempty"`
 	}
 	resObj := Error{
-		Error: fmt.Sprintf("%s (%d)", e.Message, e.StatusCode),
-		Type:  "error",
+		Error:     fmt.Sprintf("%s (%d)", e.Message, e.StatusCode),
+		Message:   e.Message,
+		Code:      e.ErrorCode(),
+		Type:      "error",
+		RequestID: e.RequestID,
 	}
 	res, _ := json.Marshal(resObj)
 	return res
@@ -0,0 +1,33 @@
+package obj
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHTTPErrorJson(t *testing.T) {
+	var res map[string]string
+
+	assert.NoError(t, json.Unmarshal(NewHTTPError(404, "Game not found").Json(), &res))
+	assert.Equal(t, "error", res["type"])
+	assert.Equal(t, ErrorCodeNotFound, res["code"])
+	assert.Equal(t, "Game not found (404)", res["error"])
+	assert.Equal(t, "Game not found", res["message"])
+
+	httpErr := &HTTPError{StatusCode: 401, Code: ErrorCodeApiKeyUnavailable, Message: "missing API key"}
+	assert.NoError(t, json.Unmarshal(httpErr.Json(), &res))
+	assert.Equal(t, ErrorCodeApiKeyUnavailable, res["code"])
+	assert.NotContains(t, res, "requestId")
+
+	httpErr.RequestID = RequestID(WithRequestID(context.Background(), "3f2a9c"))
+	res = nil
+	assert.NoError(t, json.Unmarshal(httpErr.Json(), &res))
+	assert.Equal(t, "3f2a9c", res["requestId"])
+
+	assert.Equal(t, ErrorCodeInternal, NewHTTPError(418, "teapot").ErrorCode())
+	assert.Equal(t, ErrorCodeConflict, NewHTTPError(409, "conflict").ErrorCode())
+	assert.Equal(t, ErrorCodePayloadTooLarge, NewHTTPError(413, "too large").ErrorCode())
+	assert.Equal(t, "token_expired", HTTPError{StatusCode: 401, Code: ErrorCodeTokenExpired}.ErrorCode())
+}
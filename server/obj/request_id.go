@@ -0,0 +1,31 @@
+package obj
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// RequestIDHeader carries the id correlating a request with its log lines - clients may send their own
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context, which carries the request id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id carried by the context, or "" if there is none
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// Logf logs like log.Printf, prefixed with the request id of the context
+func Logf(ctx context.Context, format string, v ...interface{}) {
+	if requestID := RequestID(ctx); requestID != "" {
+		format = fmt.Sprintf("[%s] %s", requestID, format)
+	}
+	log.Printf(format, v...)
+}
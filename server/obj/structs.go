@@ -1,46 +1,182 @@
 package obj
 
+import "time"
+
 type User struct {
-	ID                uint   `json:"id"`
-	Name              string `json:"name"`
-	OpenAiKeyPublish  string `json:"openaiKeyPublish"`
-	OpenAiKeyPersonal string `json:"openaiKeyPersonal"`
+	ID                      uint         `json:"id"`
+	Name                    string       `json:"name"`
+	OpenAiKeyPublish        string       `json:"openaiKeyPublish"`
+	OpenAiKeyPublishStatus  ApiKeyStatus `json:"openaiKeyPublishStatus"`
+	OpenAiKeyPersonal       string       `json:"openaiKeyPersonal"`
+	OpenAiKeyPersonalStatus ApiKeyStatus `json:"openaiKeyPersonalStatus"`
+}
+
+const ApiKeyPersonal = "personal"
+const ApiKeyPublish = "publish"
+
+// ApiKeyStatus is the result of the last check of an api key against the provider
+type ApiKeyStatus struct {
+	Valid       bool       `json:"valid"`
+	ValidatedAt *time.Time `json:"validatedAt"`
 }
 
 type Game struct {
-	ID                  uint   `json:"id"`
-	Title               string `json:"title"`
-	TitleImage          []byte
+	ID                     uint          `json:"id"`
+	Title                  string        `json:"title"`
+	TitleImage             []byte        `json:"-"`
+	HasTitleImage          bool          `json:"hasTitleImage"`
+	Description            string        `json:"description"`
+	Scenario               string        `json:"scenario"`
+	SessionStartSyscall    string        `json:"sessionStartSyscall"`
+	StatusFields           []StatusField `json:"statusFields"`
+	Tags                   []string      `json:"tags"`
+	ImageStyle             string        `json:"imageStyle"`
+	Temperature            *float32      `json:"temperature"`
+	TopP                   *float32      `json:"topP"`
+	MaxOutputTokens        int           `json:"maxOutputTokens"`
+	MinTurns               int           `json:"minTurns"` // player actions before the story may end, 0 = no minimum
+	MaxTurns               int           `json:"maxTurns"` // player actions after which the session ends, 0 = unlimited
+	SharePlayActive        bool          `json:"sharePlayActive"`
+	SponsorNotice          string        `json:"sponsorNotice"`
+	SponsorConsentRequired bool          `json:"sponsorConsentRequired"`
+	PlayCount              int           `json:"playCount"`
+	SharePlayHash          string        `json:"sharePlayHash"`
+	ShareEditActive        bool          `json:"shareEditActive"`
+	ShareEditHash          string        `json:"shareEditHash"`
+	UserId                 uint          `json:"userId"`
+	UserName               string        `json:"userName"`
+	DeletedAt              *time.Time    `json:"deletedAt,omitempty"`
+}
+
+const GameExportFormat = "chatgamelab-game"
+const GameExportVersion = 1
+
+// GameExport is a self-contained game, which can be imported on any instance.
+// It holds no ids, share hashes or sponsoring settings, as these belong to the exporting user.
+type GameExport struct {
+	Format              string        `json:"format"`
+	Version             int           `json:"version"`
+	Title               string        `json:"title"`
 	Description         string        `json:"description"`
 	Scenario            string        `json:"scenario"`
 	SessionStartSyscall string        `json:"sessionStartSyscall"`
 	StatusFields        []StatusField `json:"statusFields"`
+	Tags                []string      `json:"tags,omitempty"`
 	ImageStyle          string        `json:"imageStyle"`
-	SharePlayActive     bool          `json:"sharePlayActive"`
-	SharePlayHash       string        `json:"sharePlayHash"`
-	ShareEditActive     bool          `json:"shareEditActive"`
-	ShareEditHash       string        `json:"shareEditHash"`
-	UserId              uint          `json:"userId"`
-	UserName            string        `json:"userName"`
+	Temperature         *float32      `json:"temperature,omitempty"`
+	TopP                *float32      `json:"topP,omitempty"`
+	MaxOutputTokens     int           `json:"maxOutputTokens,omitempty"`
+	MinTurns            int           `json:"minTurns,omitempty"`
+	MaxTurns            int           `json:"maxTurns,omitempty"`
+	TitleImage          []byte        `json:"titleImage,omitempty"`
 }
 
 type Session struct {
-	ID                    uint   `json:"id"`
-	GameID                uint   `json:"gameId"`
-	UserID                uint   `json:"userId"`
-	AssistantID           string `json:"assistantId"`
-	AssistantInstructions string `json:"assistantInstructions"`
-	ThreadID              string `json:"threadId"`
-	Hash                  string `json:"hash"`
+	ID                    uint      `json:"id"`
+	GameID                uint      `json:"gameId"`
+	UserID                uint      `json:"userId"`
+	AssistantID           string    `json:"assistantId"`
+	AssistantInstructions string    `json:"assistantInstructions"`
+	ThreadID              string    `json:"threadId"`
+	Hash                  string    `json:"hash"`
+	CreatedAt             time.Time `json:"createdAt"`
+	ApiKeySource          string    `json:"apiKeySource"`
+	SponsorNotice         string    `json:"sponsorNotice,omitempty"`
+	Turns                 int       `json:"turns"`
+	EndReason             string    `json:"endReason,omitempty"`
+}
+
+// Sources of the api key a session is played with
+const ApiKeySourcePersonal = "personal" // the player's personal key
+const ApiKeySourceSponsor = "sponsor"   // the publish key of the game's owner, for public games
+
+// Reasons for a session to end - ended sessions don't accept any more player actions
+const SessionEndReasonTurnLimit = "turn-limit" // the player used up the game's MaxTurns
+
+const (
+	GameSortTitle     = "title"
+	GameSortCreatedAt = "createdAt"
+	GameSortPlayCount = "playCount"
+)
+
+// GameListOptions filters, sorts and pages the games listed to a user.
+// Public and Mine are tri-state: nil doesn't filter.
+type GameListOptions struct {
+	Search     string
+	Tag        string
+	Public     *bool
+	Mine       *bool
+	Sort       string
+	Descending bool
+	Limit      int // 0 = no limit
+	Offset     int
+}
+
+// GameList is a page of the games listed to a user
+type GameList struct {
+	Games []Game `json:"games"`
+	Total int64  `json:"total"` // games matching the filters on all pages
+}
+
+// SessionSummary describes a session of a game without exposing the session hash or the player's identity
+type SessionSummary struct {
+	ID           uint      `json:"id"`
+	GameID       uint      `json:"gameId"`
+	Player       string    `json:"player"`
+	Chapters     int64     `json:"chapters"`
+	ApiKeySource string    `json:"apiKeySource"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	// ImageErrors tell the owner, why chapters of the session were left without image
+	ImageErrors []ChapterImageError `json:"imageErrors,omitempty"`
+}
+
+type ChapterImageError struct {
+	Chapter uint   `json:"chapter"`
+	Error   string `json:"error"`
 }
 
 type Chapter struct {
-	SessionID   uint   `json:"sessionId"`
-	Chapter     uint   `json:"chapter"`
-	Input       string `json:"input"`
-	Output      string `json:"output"`
-	ImagePrompt string `json:"imagePrompt"`
-	Image       []byte `json:"image"`
+	SessionID   uint      `json:"sessionId"`
+	Chapter     uint      `json:"chapter"`
+	Input       string    `json:"input"`
+	Output      string    `json:"output"`
+	ImagePrompt string    `json:"imagePrompt"`
+	Image       []byte    `json:"image"`
+	ImageError  string    `json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// File is a handler result, which is sent as a download instead of being serialized
+type File struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+// JsonResponse is a handler result, which is serialized like any other, but sent with its own status code
+type JsonResponse struct {
+	StatusCode int
+	Body       interface{}
+}
+
+// SessionTranscript is the complete, ordered log of a session
+type SessionTranscript struct {
+	SessionHash string              `json:"sessionHash"`
+	GameID      uint                `json:"gameId"`
+	GameTitle   string              `json:"gameTitle"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	Chapters    []TranscriptChapter `json:"chapters"`
+}
+
+type TranscriptChapter struct {
+	Chapter     uint          `json:"chapter"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	Type        string        `json:"type"`
+	Action      string        `json:"action"`
+	Story       string        `json:"story"`
+	Status      []StatusField `json:"status"`
+	ImagePrompt string        `json:"imagePrompt"`
 }
 
 type StatusField struct {
@@ -58,6 +194,8 @@ type GameActionInput struct {
 	Type      string        `json:"type"`
 	Message   string        `json:"action"`
 	Status    []StatusField `json:"status"`
+	Turn      int           `json:"turn,omitempty"`     // number of the player action, if the game has turn limits
+	LastTurn  bool          `json:"lastTurn,omitempty"` // the story must come to an end with this action
 }
 
 /*
@@ -79,4 +217,5 @@ type GameActionOutput struct {
 	RawInput              string        `json:"rawInput"`
 	RawOutput             string        `json:"rawOutput"`
 	AssistantInstructions string        `json:"assistantInstructions"`
+	EndReason             string        `json:"endReason,omitempty"`
 }
@@ -3,6 +3,7 @@ package router
 import (
 	"net/http"
 	"os"
+	"webapp-server/obj"
 )
 
 var corsAllowedOrigin string
@@ -13,7 +14,8 @@ func SetCorsHeaders(w http.ResponseWriter) {
 	}
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Access-Control-Allow-Origin", corsAllowedOrigin)
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, "+obj.RequestIDHeader)
+	w.Header().Set("Access-Control-Expose-Headers", obj.RequestIDHeader)
 }
 
 func SetNoCacheHeaders(w http.ResponseWriter) {
@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
@@ -12,6 +13,8 @@ import (
 	"github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/jwks"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+	"webapp-server/obj"
 )
 
 // CustomClaims contains custom data we want from the token.
@@ -63,11 +66,19 @@ func EnsureValidToken() func(next http.Handler) http.Handler {
 	}
 
 	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Encountered error while validating JWT: %v", err)
+		obj.Logf(r.Context(), "Encountered error while validating JWT: %v", err)
+
+		httpError := obj.HTTPError{StatusCode: http.StatusUnauthorized, Code: obj.ErrorCodeTokenInvalid, Message: "Failed to validate JWT.", RequestID: obj.RequestID(r.Context())}
+		switch {
+		case errors.Is(err, jwtmiddleware.ErrJWTMissing):
+			httpError.Code = obj.ErrorCodeTokenMissing
+		case errors.Is(err, jwt.ErrExpired):
+			httpError.Code = obj.ErrorCodeTokenExpired
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`{"message":"Failed to validate JWT."}`))
+		w.WriteHeader(httpError.StatusCode)
+		_, _ = w.Write(httpError.Json())
 	}
 
 	middleware := jwtmiddleware.New(
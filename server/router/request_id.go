@@ -0,0 +1,30 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"webapp-server/obj"
+)
+
+// validRequestID limits ids taken over from clients, as they end up in logs and headers
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// WithRequestID takes over the client's X-Request-ID or generates one, stores it in the request context and echoes it in the response
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(obj.RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(obj.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(obj.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+func newRequestID() string {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
@@ -0,0 +1,35 @@
+package router
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webapp-server/obj"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = obj.RequestID(r.Context())
+	}))
+	serve := func(header string) string {
+		request := httptest.NewRequest("GET", "/api/games", nil)
+		if header != "" {
+			request.Header.Set(obj.RequestIDHeader, header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, seen, recorder.Header().Get(obj.RequestIDHeader))
+		return seen
+	}
+
+	// the client's id is taken over and echoed
+	assert.Equal(t, "client-42.a_b", serve("client-42.a_b"))
+
+	// ids which could break logs or headers are replaced
+	for _, header := range []string{"", "bad id", "id\nwith newline", string(make([]byte, 65))} {
+		requestID := serve(header)
+		assert.Regexp(t, `^[0-9a-f]{16}$`, requestID, header)
+	}
+}
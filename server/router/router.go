@@ -3,9 +3,9 @@ package router
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
-	"log"
 	"net/http"
 	"webapp-server/db"
 	"webapp-server/obj"
@@ -23,7 +23,7 @@ type Endpoint struct {
 type Request struct {
 	R    *http.Request
 	User *db.User
-	Ctx  context.Context
+	Ctx  context.Context // carries the request id, but isn't cancelled with the request
 }
 
 type Handler func(request Request) (interface{}, *obj.HTTPError)
@@ -42,14 +42,14 @@ func NewEndpoint(path string, public bool, contentType string, handler Handler)
 
 		request := Request{
 			R:   r,
-			Ctx: context.Background(),
+			Ctx: obj.WithRequestID(context.Background(), obj.RequestID(r.Context())),
 		}
 
 		SetCorsHeaders(w)
 		SetNoCacheHeaders(w)
 		w.Header().Set("Content-Type", endpoint.ContentType)
 
-		log.Printf("Handling request for %s", r.URL.Path)
+		obj.Logf(request.Ctx, "Handling request for %s", r.URL.Path)
 		tokenObj := r.Context().Value(jwtmiddleware.ContextKey{})
 		if tokenObj != nil {
 			token := tokenObj.(*validator.ValidatedClaims)
@@ -82,11 +82,22 @@ func NewEndpoint(path string, public bool, contentType string, handler Handler)
 
 		var res interface{}
 		if httpError == nil {
-			log.Printf("Passing over to handler")
+			obj.Logf(request.Ctx, "Passing over to handler")
 			res, httpError = handler(request)
 		}
 
 		var resBytes []byte
+		if file, ok := res.(*obj.File); ok && httpError == nil {
+			w.Header().Set("Content-Type", file.ContentType)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(file.Content)
+			return
+		}
+		statusCode := http.StatusOK
+		if response, ok := res.(*obj.JsonResponse); ok && httpError == nil {
+			statusCode, res = response.StatusCode, response.Body
+		}
 		if httpError == nil {
 			switch endpoint.ContentType {
 			case "application/json":
@@ -101,13 +112,15 @@ func NewEndpoint(path string, public bool, contentType string, handler Handler)
 		}
 
 		if httpError != nil {
+			obj.Logf(request.Ctx, "Request for %s failed: %s (%d)", r.URL.Path, httpError.Message, httpError.StatusCode)
+			httpError.RequestID = obj.RequestID(request.Ctx)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(httpError.StatusCode)
 			_, _ = w.Write(httpError.Json())
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(statusCode)
 		_, _ = w.Write(resBytes)
 	}
 
@@ -127,11 +140,11 @@ func NewRouter(endpoints []Endpoint) *http.ServeMux {
 
 	for _, endpoint := range endpoints {
 		if endpoint.Public {
-			router.Handle(endpoint.Path, endpoint.Handler)
+			router.Handle(endpoint.Path, WithRequestID(endpoint.Handler))
 		} else {
-			router.Handle(endpoint.Path, EnsureValidToken()(
+			router.Handle(endpoint.Path, WithRequestID(EnsureValidToken()(
 				endpoint.Handler,
-			))
+			)))
 		}
 	}
 	return router